@@ -0,0 +1,244 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+const (
+	CHANNEL_WEBHOOK = "webhook"
+	CHANNEL_SLACK   = "slack"
+	CHANNEL_MATRIX  = "matrix"
+	CHANNEL_SMTP    = "smtp"
+)
+
+// notifyTimeout limits how long a single notification may take
+const notifyTimeout = 10 * time.Second
+
+// Notifier delivers an alert to one channel
+type Notifier interface {
+	Notify(ctx context.Context, alert model.Alert) error
+}
+
+func NewNotifier(channel config.ConfigAlertChannel) (Notifier, error) {
+	httpClient := &http.Client{Timeout: notifyTimeout}
+
+	switch channel.Type {
+	case CHANNEL_WEBHOOK:
+		if channel.URL == "" {
+			return nil, fmt.Errorf("alert channel %s: url is required", channel.Name)
+		}
+		return &webhookNotifier{httpClient: httpClient, url: channel.URL, headers: channel.Headers}, nil
+	case CHANNEL_SLACK:
+		if channel.URL == "" {
+			return nil, fmt.Errorf("alert channel %s: url is required", channel.Name)
+		}
+		return &slackNotifier{httpClient: httpClient, url: channel.URL}, nil
+	case CHANNEL_MATRIX:
+		if channel.URL == "" || channel.RoomID == "" || channel.AccessToken == "" {
+			return nil, fmt.Errorf("alert channel %s: url, room_id and access_token are required", channel.Name)
+		}
+		return &matrixNotifier{httpClient: httpClient, channel: channel.Name, url: strings.TrimSuffix(channel.URL, "/"), roomID: channel.RoomID, accessToken: channel.AccessToken}, nil
+	case CHANNEL_SMTP:
+		if channel.SMTP.Host == "" || channel.SMTP.From == "" || len(channel.SMTP.To) == 0 {
+			return nil, fmt.Errorf("alert channel %s: smtp host, from and to are required", channel.Name)
+		}
+		return newSmtpNotifier(channel), nil
+	default:
+		return nil, fmt.Errorf("alert channel %s: unknown type %q", channel.Name, channel.Type)
+	}
+}
+
+// postJSON sends the payload and treats every status other than 2xx as error
+func postJSON(ctx context.Context, httpClient *http.Client, method string, url string, headers map[string]string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s responded with %d: %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// webhookNotifier posts the alert as json
+type webhookNotifier struct {
+	httpClient *http.Client
+	url        string
+	headers    map[string]string
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, alert model.Alert) error {
+	return postJSON(ctx, n.httpClient, http.MethodPost, n.url, n.headers, alert)
+}
+
+// slackNotifier posts the message to an incoming webhook of Slack or a compatible chat,
+// e.g. Mattermost or Rocket.Chat
+type slackNotifier struct {
+	httpClient *http.Client
+	url        string
+}
+
+func (n *slackNotifier) Notify(ctx context.Context, alert model.Alert) error {
+	return postJSON(ctx, n.httpClient, http.MethodPost, n.url, nil, map[string]string{
+		"text": alert.Message,
+	})
+}
+
+// matrixNotifier sends the message to a room with the client-server API of the homeserver
+type matrixNotifier struct {
+	httpClient  *http.Client
+	channel     string
+	url         string
+	roomID      string
+	accessToken string
+}
+
+func (n *matrixNotifier) Notify(ctx context.Context, alert model.Alert) error {
+	// the transaction id is derived from the notified state, so the homeserver ignores
+	// a retry of a notification it already received
+	state := model.AlertState{Rule: alert.Rule, Channel: n.channel, Certname: alert.Certname}
+	txnID := sha256.Sum256([]byte(state.Id() + "/" + alert.Key))
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s", n.url, url.PathEscape(n.roomID), hex.EncodeToString(txnID[:]))
+
+	return postJSON(ctx, n.httpClient, http.MethodPut, endpoint, map[string]string{
+		"Authorization": "Bearer " + n.accessToken,
+	}, map[string]string{
+		"msgtype": "m.text",
+		"body":    alert.Message,
+	})
+}
+
+// smtpNotifier mails the message, STARTTLS is used when the server offers it
+type smtpNotifier struct {
+	host    string
+	address string
+	auth    smtp.Auth
+	from    string
+	to      []string
+}
+
+func newSmtpNotifier(channel config.ConfigAlertChannel) *smtpNotifier {
+	port := channel.SMTP.Port
+	if port == 0 {
+		port = 25
+	}
+
+	n := &smtpNotifier{
+		host:    channel.SMTP.Host,
+		address: net.JoinHostPort(channel.SMTP.Host, strconv.FormatUint(port, 10)),
+		from:    channel.SMTP.From,
+		to:      channel.SMTP.To,
+	}
+
+	if channel.SMTP.Username != "" {
+		n.auth = smtp.PlainAuth("", channel.SMTP.Username, channel.SMTP.Password, channel.SMTP.Host)
+	}
+
+	return n
+}
+
+func (n *smtpNotifier) Notify(ctx context.Context, alert model.Alert) error {
+	subject := alert.Certname
+	if subject == "" {
+		subject = fmt.Sprintf("%d alerts", len(alert.Certnames))
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", n.from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&message, "Subject: [openvoxview] %s: %s\r\n", alert.Rule, subject)
+	fmt.Fprintf(&message, "Date: %s\r\n", alert.Timestamp.Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&message, "%s\r\n", alert.Message)
+
+	// net/smtp has no context support, the deadline of the connection ends a hanging
+	// server instead
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", n.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, hasDeadline := ctx.Deadline(); hasDeadline {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if hasStartTLS, _ := client.Extension("STARTTLS"); hasStartTLS {
+		if err := client.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+			return err
+		}
+	}
+
+	if n.auth != nil {
+		if err := client.Auth(n.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(message.Bytes()); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,421 @@
+package alerting
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/metrics"
+	"github.com/sebastianrakel/openvoxview/model"
+	"github.com/sebastianrakel/openvoxview/puppetca"
+	"github.com/sebastianrakel/openvoxview/puppetdb"
+)
+
+// DEFAULT_MAX_NOTIFICATIONS is the number of notifications a rule sends to a channel per
+// evaluation when max_notifications is not set, more alerts are sent as one summary
+const DEFAULT_MAX_NOTIFICATIONS = 10
+
+// summaryLines limits the alert messages listed in a summary
+const summaryLines = 50
+
+// Runner periodically evaluates the alert rules against the current state of the fleet.
+// A node is notified once per state (e.g. per failed report) and channel, the state
+// store remembers what was sent.
+type Runner struct {
+	pdbClient       *puppetdb.Client
+	caClient        *puppetca.Client
+	store           StateStore
+	notifiers       map[string]Notifier
+	rules           []config.ConfigAlertRule
+	interval        time.Duration
+	unreportedHours uint64
+}
+
+// NewRunner validates the channels and rules, without caClient only the node
+// conditions can be used
+func NewRunner(cfg *config.Config, pdbClient *puppetdb.Client, caClient *puppetca.Client) (*Runner, error) {
+	if cfg.Alerting.Interval <= 0 {
+		return nil, fmt.Errorf("alerting interval must be positive, got %s", cfg.Alerting.Interval)
+	}
+
+	r := &Runner{
+		pdbClient:       pdbClient.WithoutCache(),
+		caClient:        caClient,
+		notifiers:       map[string]Notifier{},
+		interval:        cfg.Alerting.Interval,
+		unreportedHours: cfg.UnreportedHours,
+	}
+
+	for _, channel := range cfg.Alerting.Channels {
+		if channel.Name == "" {
+			return nil, errors.New("alert channel without name")
+		}
+		if _, exists := r.notifiers[channel.Name]; exists {
+			return nil, fmt.Errorf("alert channel %s is defined twice", channel.Name)
+		}
+
+		notifier, err := NewNotifier(channel)
+		if err != nil {
+			return nil, err
+		}
+		r.notifiers[channel.Name] = notifier
+	}
+
+	names := map[string]bool{}
+	for _, rule := range cfg.Alerting.Rules {
+		if rule.Name == "" {
+			return nil, errors.New("alert rule without name")
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("alert rule %s is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if !slices.Contains(model.AlertConditions, rule.Condition) {
+			return nil, fmt.Errorf("alert rule %s: unknown condition %q", rule.Name, rule.Condition)
+		}
+		if isCertificateCondition(rule.Condition) && caClient == nil {
+			return nil, fmt.Errorf("alert rule %s: condition %s needs puppetca", rule.Name, rule.Condition)
+		}
+
+		if len(rule.Channels) == 0 {
+			return nil, fmt.Errorf("alert rule %s: no channels", rule.Name)
+		}
+		for _, channel := range rule.Channels {
+			if _, exists := r.notifiers[channel]; !exists {
+				return nil, fmt.Errorf("alert rule %s: unknown channel %s", rule.Name, channel)
+			}
+		}
+
+		if rule.Days == 0 {
+			rule.Days = cfg.PuppetCA.ExpiryDays
+		}
+
+		switch {
+		case rule.MaxNotifications == 0:
+			rule.MaxNotifications = DEFAULT_MAX_NOTIFICATIONS
+		case rule.MaxNotifications < 0:
+			return nil, fmt.Errorf("alert rule %s: max_notifications must be positive, got %d", rule.Name, rule.MaxNotifications)
+		}
+
+		r.rules = append(r.rules, rule)
+	}
+
+	store, err := NewStateStore(cfg.Alerting.Backend, cfg.Alerting.Path)
+	if err != nil {
+		return nil, err
+	}
+	r.store = store
+
+	return r, nil
+}
+
+func isCertificateCondition(condition string) bool {
+	return condition == model.AlertConditionCertificateRequest || condition == model.AlertConditionCertificateExpiry
+}
+
+func (r *Runner) Run(ctx context.Context) {
+	slog.Info("alerting started", "interval", r.interval, "rules", len(r.rules))
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.check(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) Close() error {
+	return r.store.Close()
+}
+
+// fleet is the data the rules are evaluated against, fetched once per check and only
+// if a rule needs it
+type fleet struct {
+	nodes     []model.Node
+	requests  []model.CertificateStatus
+	signed    []model.CertificateStatus
+	available map[string]bool
+}
+
+func (r *Runner) fetch() fleet {
+	needed := map[string]bool{}
+	for _, rule := range r.rules {
+		needed[rule.Condition] = true
+	}
+
+	f := fleet{available: map[string]bool{}}
+
+	if needed[model.AlertConditionFailed] || needed[model.AlertConditionUnreported] {
+		nodes, err := r.pdbClient.GetNodes(&puppetdb.PdbQuery{})
+		if err != nil {
+			slog.Error("alerting: error getting nodes", "error", err)
+		} else {
+			f.nodes = nodes
+			f.available[model.AlertConditionFailed] = true
+			f.available[model.AlertConditionUnreported] = true
+		}
+	}
+
+	if needed[model.AlertConditionCertificateRequest] {
+		state := model.CertificateRequested
+		certs, err := r.caClient.GetCertificates(&state)
+		if err != nil {
+			slog.Error("alerting: error getting certificate requests", "error", err)
+		} else {
+			f.requests = certs
+			f.available[model.AlertConditionCertificateRequest] = true
+		}
+	}
+
+	if needed[model.AlertConditionCertificateExpiry] {
+		state := model.CertificateSigned
+		certs, err := r.caClient.GetCertificates(&state)
+		if err != nil {
+			slog.Error("alerting: error getting certificates", "error", err)
+		} else {
+			f.signed = certs
+			f.available[model.AlertConditionCertificateExpiry] = true
+		}
+	}
+
+	return f
+}
+
+// check notifies the alerts of all rules and removes the states of nodes that no longer
+// match their rule, once their throttle is over
+func (r *Runner) check(ctx context.Context, now time.Time) {
+	f := r.fetch()
+
+	active := map[string]bool{}
+	evaluated := map[string]bool{}
+
+	for _, rule := range r.rules {
+		if !f.available[rule.Condition] {
+			continue
+		}
+		evaluated[rule.Name] = true
+
+		alerts := r.evaluate(rule, f, now)
+		for _, channel := range rule.Channels {
+			due := []model.Alert{}
+			for _, alert := range alerts {
+				state := model.AlertState{Rule: rule.Name, Channel: channel, Certname: alert.Certname}
+				active[state.Id()] = true
+
+				if r.due(rule, channel, alert, now) {
+					due = append(due, alert)
+				}
+			}
+
+			r.deliver(ctx, rule, channel, due, now)
+		}
+	}
+
+	states, err := r.store.List()
+	if err != nil {
+		slog.Error("alerting: error listing states", "error", err)
+		return
+	}
+
+	for _, state := range states {
+		rule := slices.IndexFunc(r.rules, func(rule config.ConfigAlertRule) bool {
+			return rule.Name == state.Rule && slices.Contains(rule.Channels, state.Channel)
+		})
+
+		switch {
+		case rule < 0:
+			// the rule or channel was removed from the config
+		case !evaluated[state.Rule] || active[state.Id()]:
+			continue
+		case now.Sub(state.SentAt) < r.rules[rule].Throttle:
+			continue
+		}
+
+		if err := r.store.Delete(state.Id()); err != nil {
+			slog.Error("alerting: error deleting state", "id", state.Id(), "error", err)
+		}
+	}
+}
+
+// due is true unless the alert was already sent or the rule is throttled for the node
+func (r *Runner) due(rule config.ConfigAlertRule, channel string, alert model.Alert, now time.Time) bool {
+	state := model.AlertState{Rule: rule.Name, Channel: channel, Certname: alert.Certname}
+
+	previous, found, err := r.store.Get(state.Id())
+	if err != nil {
+		slog.Error("alerting: error reading state", "id", state.Id(), "error", err)
+		return false
+	}
+
+	if found && previous.Key == alert.Key {
+		return false
+	}
+
+	if found && now.Sub(previous.SentAt) < rule.Throttle {
+		slog.Debug("alerting: throttled", "rule", rule.Name, "channel", channel, "certname", alert.Certname)
+		metrics.ObserveAlert(rule.Name, channel, metrics.ALERT_THROTTLED)
+		return false
+	}
+
+	return true
+}
+
+// deliver sends the due alerts of a rule to the channel, more than max_notifications are
+// sent as one summary. Failed notifications are retried with the next check.
+func (r *Runner) deliver(ctx context.Context, rule config.ConfigAlertRule, channel string, alerts []model.Alert, now time.Time) {
+	if len(alerts) <= rule.MaxNotifications {
+		for _, alert := range alerts {
+			if r.notify(ctx, rule, channel, alert) {
+				r.sent(rule, channel, alert, now)
+			}
+		}
+		return
+	}
+
+	if !r.notify(ctx, rule, channel, summarize(rule, alerts, now)) {
+		return
+	}
+
+	for _, alert := range alerts {
+		metrics.ObserveAlert(rule.Name, channel, metrics.ALERT_BATCHED)
+		r.sent(rule, channel, alert, now)
+	}
+}
+
+func (r *Runner) notify(ctx context.Context, rule config.ConfigAlertRule, channel string, alert model.Alert) bool {
+	notifyCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+
+	if err := r.notifiers[channel].Notify(notifyCtx, alert); err != nil {
+		slog.Error("alerting: error sending notification", "rule", rule.Name, "channel", channel, "certname", alert.Certname, "alerts", len(alert.Certnames), "error", err)
+		metrics.ObserveAlert(rule.Name, channel, metrics.ALERT_FAILED)
+		return false
+	}
+
+	slog.Info("alerting: notification sent", "rule", rule.Name, "channel", channel, "certname", alert.Certname, "alerts", len(alert.Certnames))
+	metrics.ObserveAlert(rule.Name, channel, metrics.ALERT_SENT)
+	return true
+}
+
+// sent remembers the notification, so the same state is not notified again
+func (r *Runner) sent(rule config.ConfigAlertRule, channel string, alert model.Alert, now time.Time) {
+	state := model.AlertState{
+		Rule:     rule.Name,
+		Channel:  channel,
+		Certname: alert.Certname,
+		Key:      alert.Key,
+		SentAt:   now,
+	}
+
+	if err := r.store.Put(state); err != nil {
+		slog.Error("alerting: error storing state", "id", state.Id(), "error", err)
+	}
+}
+
+// summarize combines the alerts into one, the key identifies the combined states
+func summarize(rule config.ConfigAlertRule, alerts []model.Alert, now time.Time) model.Alert {
+	summary := model.Alert{
+		Rule:      rule.Name,
+		Condition: rule.Condition,
+		Certnames: []string{},
+		Timestamp: now,
+	}
+
+	lines := []string{fmt.Sprintf("%s: %d alerts", rule.Name, len(alerts))}
+	hash := sha256.New()
+	for i, alert := range alerts {
+		summary.Certnames = append(summary.Certnames, alert.Certname)
+		fmt.Fprintf(hash, "%s/%s\n", alert.Certname, alert.Key)
+
+		if i < summaryLines {
+			lines = append(lines, alert.Message)
+		}
+	}
+
+	if len(alerts) > summaryLines {
+		lines = append(lines, fmt.Sprintf("... and %d more", len(alerts)-summaryLines))
+	}
+
+	summary.Message = strings.Join(lines, "\n")
+	summary.Key = hex.EncodeToString(hash.Sum(nil))
+	return summary
+}
+
+// evaluate returns the alerts of the rule, the environments of the rule only apply to
+// the node conditions
+func (r *Runner) evaluate(rule config.ConfigAlertRule, f fleet, now time.Time) []model.Alert {
+	alerts := []model.Alert{}
+
+	alert := func(certname string, environment string, key string, message string) {
+		alerts = append(alerts, model.Alert{
+			Rule:        rule.Name,
+			Condition:   rule.Condition,
+			Certname:    certname,
+			Environment: environment,
+			Message:     message,
+			Key:         key,
+			Timestamp:   now,
+		})
+	}
+
+	switch rule.Condition {
+	case model.AlertConditionFailed, model.AlertConditionUnreported:
+		for _, node := range f.nodes {
+			environment := node.Environment()
+			if len(rule.Environments) > 0 && !slices.Contains(rule.Environments, environment) {
+				continue
+			}
+
+			label := node.Name
+			if environment != "" {
+				label = fmt.Sprintf("%s (%s)", node.Name, environment)
+			}
+
+			if rule.Condition == model.AlertConditionFailed {
+				if node.LatestReportStatus == "failed" {
+					alert(node.Name, environment, node.LatestReportHash, fmt.Sprintf("%s: the latest puppet run failed", label))
+				}
+				continue
+			}
+
+			if !node.IsUnreported(now, r.unreportedHours) {
+				continue
+			}
+			if node.ReportTimestamp == nil {
+				alert(node.Name, environment, "never", fmt.Sprintf("%s: has never reported", label))
+			} else {
+				reported := node.ReportTimestamp.Format(time.RFC3339)
+				alert(node.Name, environment, reported, fmt.Sprintf("%s: no report since %s", label, reported))
+			}
+		}
+	case model.AlertConditionCertificateRequest:
+		for _, cert := range f.requests {
+			alert(cert.Name, "", cert.Fingerprint, fmt.Sprintf("%s: certificate request waiting to be signed, fingerprint %s", cert.Name, cert.Fingerprint))
+		}
+	case model.AlertConditionCertificateExpiry:
+		for _, cert := range model.ExpiringCertificates(f.signed, now, time.Duration(rule.Days)*24*time.Hour) {
+			notAfter := cert.NotAfter.Format(time.RFC3339)
+			message := fmt.Sprintf("%s: certificate expires in %d days, on %s", cert.Name, cert.DaysRemaining, notAfter)
+			if cert.DaysRemaining < 0 {
+				message = fmt.Sprintf("%s: certificate expired on %s", cert.Name, notAfter)
+			}
+			alert(cert.Name, "", notAfter, message)
+		}
+	}
+
+	return alerts
+}
@@ -0,0 +1,288 @@
+package alerting
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/model"
+	"github.com/sebastianrakel/openvoxview/puppetdb"
+)
+
+var testNow = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+// recordingNotifier keeps the sent alerts, with err set every notification fails
+type recordingNotifier struct {
+	mu     sync.Mutex
+	alerts []model.Alert
+	err    error
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, alert model.Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.err != nil {
+		return n.err
+	}
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+// take returns the certnames of the sent alerts, a summary as summary:<count>, and
+// forgets them
+func (n *recordingNotifier) take() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sent := []string{}
+	for _, alert := range n.alerts {
+		if alert.Certname == "" {
+			sent = append(sent, fmt.Sprintf("summary:%d", len(alert.Certnames)))
+		} else {
+			sent = append(sent, alert.Certname)
+		}
+	}
+	slices.SortFunc(sent, cmp.Compare)
+
+	n.alerts = nil
+	return sent
+}
+
+func testRule(throttle time.Duration, maxNotifications int) config.ConfigAlertRule {
+	return config.ConfigAlertRule{
+		Name:             "failed",
+		Condition:        model.AlertConditionFailed,
+		Throttle:         throttle,
+		MaxNotifications: maxNotifications,
+		Channels:         []string{"hook"},
+	}
+}
+
+func testRunner(rule config.ConfigAlertRule, notifier Notifier) *Runner {
+	return &Runner{
+		store:     NewMemoryStateStore(),
+		notifiers: map[string]Notifier{"hook": notifier},
+		rules:     []config.ConfigAlertRule{rule},
+	}
+}
+
+func testAlert(certname string, key string) model.Alert {
+	return model.Alert{
+		Rule:      "failed",
+		Condition: model.AlertConditionFailed,
+		Certname:  certname,
+		Key:       key,
+		Message:   certname + ": the latest puppet run failed",
+	}
+}
+
+func TestDue(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    *model.AlertState
+		throttle time.Duration
+		key      string
+		want     bool
+	}{
+		{"never sent", nil, time.Hour, "a", true},
+		{"already sent", &model.AlertState{Key: "a", SentAt: testNow.Add(-time.Minute)}, 0, "a", false},
+		{"already sent before the throttle", &model.AlertState{Key: "a", SentAt: testNow.Add(-2 * time.Hour)}, time.Hour, "a", false},
+		{"new state without throttle", &model.AlertState{Key: "a", SentAt: testNow.Add(-time.Minute)}, 0, "b", true},
+		{"new state while throttled", &model.AlertState{Key: "a", SentAt: testNow.Add(-time.Minute)}, time.Hour, "b", false},
+		{"new state after the throttle", &model.AlertState{Key: "a", SentAt: testNow.Add(-time.Hour)}, time.Hour, "b", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule := testRule(test.throttle, DEFAULT_MAX_NOTIFICATIONS)
+			r := testRunner(rule, &recordingNotifier{})
+
+			if test.state != nil {
+				state := *test.state
+				state.Rule, state.Channel, state.Certname = rule.Name, "hook", "web1"
+				r.store.Put(state)
+			}
+
+			if due := r.due(rule, "hook", testAlert("web1", test.key), testNow); due != test.want {
+				t.Errorf("due = %v, want %v", due, test.want)
+			}
+		})
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxNotifications int
+		certnames        []string
+		err              error
+		want             []string
+		stored           int
+	}{
+		{"nothing due", 2, []string{}, nil, []string{}, 0},
+		{"single notifications", 2, []string{"web1", "web2"}, nil, []string{"web1", "web2"}, 2},
+		{"summary", 2, []string{"web1", "web2", "web3"}, nil, []string{"summary:3"}, 3},
+		{"failed notifications", 2, []string{"web1", "web2"}, errors.New("unreachable"), []string{}, 0},
+		{"failed summary", 2, []string{"web1", "web2", "web3"}, errors.New("unreachable"), []string{}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule := testRule(0, test.maxNotifications)
+			notifier := &recordingNotifier{err: test.err}
+			r := testRunner(rule, notifier)
+
+			alerts := []model.Alert{}
+			for _, certname := range test.certnames {
+				alerts = append(alerts, testAlert(certname, "key-"+certname))
+			}
+			r.deliver(context.Background(), rule, "hook", alerts, testNow)
+
+			if sent := notifier.take(); !reflect.DeepEqual(sent, test.want) {
+				t.Errorf("sent = %v, want %v", sent, test.want)
+			}
+
+			states, _ := r.store.List()
+			if len(states) != test.stored {
+				t.Fatalf("stored states = %v, want %d", states, test.stored)
+			}
+			for _, state := range states {
+				if state.Key != "key-"+state.Certname || !state.SentAt.Equal(testNow) {
+					t.Errorf("state = %+v, want the key of its own alert sent at %s", state, testNow)
+				}
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	rule := testRule(0, 2)
+
+	alerts := []model.Alert{}
+	for i := range summaryLines + 5 {
+		alerts = append(alerts, testAlert(fmt.Sprintf("web%02d", i), "a"))
+	}
+
+	summary := summarize(rule, alerts, testNow)
+	if summary.Certname != "" || len(summary.Certnames) != len(alerts) || summary.Rule != rule.Name {
+		t.Errorf("summary = %+v, want all certnames of the rule", summary)
+	}
+
+	lines := strings.Split(summary.Message, "\n")
+	if len(lines) != summaryLines+2 || lines[0] != "failed: 55 alerts" || lines[len(lines)-1] != "... and 5 more" {
+		t.Errorf("summary message has %d lines from %q to %q", len(lines), lines[0], lines[len(lines)-1])
+	}
+
+	tests := []struct {
+		name    string
+		alerts  []model.Alert
+		sameKey bool
+	}{
+		{"same alerts", slices.Clone(alerts), true},
+		{"changed key", append(slices.Clone(alerts[:len(alerts)-1]), testAlert(alerts[len(alerts)-1].Certname, "b")), false},
+		{"other node", append(slices.Clone(alerts[:len(alerts)-1]), testAlert("db1", "a")), false},
+		{"fewer alerts", slices.Clone(alerts[1:]), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			other := summarize(rule, test.alerts, testNow.Add(time.Hour))
+			if (other.Key == summary.Key) != test.sameKey {
+				t.Errorf("key %s of %s, key of the first summary %s, want the same key: %v", other.Key, test.name, summary.Key, test.sameKey)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var mu sync.Mutex
+	nodes := []model.Node{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewEncoder(w).Encode(nodes)
+	}))
+	defer server.Close()
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.PuppetDB.Host = host
+	cfg.PuppetDB.Port, _ = strconv.ParseUint(port, 10, 64)
+
+	notifier := &recordingNotifier{}
+	r := testRunner(testRule(time.Hour, 2), notifier)
+	r.pdbClient = puppetdb.NewClient(cfg)
+
+	// the state of a rule that was removed from the config
+	r.store.Put(model.AlertState{Rule: "removed", Channel: "hook", Certname: "web1", Key: "a", SentAt: testNow})
+
+	node := func(certname string, status string, hash string) model.Node {
+		return model.Node{Name: certname, LatestReportStatus: status, LatestReportHash: hash}
+	}
+
+	steps := []struct {
+		name   string
+		after  time.Duration
+		nodes  []model.Node
+		sent   []string
+		states []string
+	}{
+		{"first failure", 0, []model.Node{node("web1", "failed", "a"), node("web2", "changed", "x")}, []string{"web1"}, []string{"web1:a"}},
+		{"same failure", time.Minute, []model.Node{node("web1", "failed", "a"), node("web2", "changed", "x")}, []string{}, []string{"web1:a"}},
+		{"new failure while throttled", 2 * time.Minute, []model.Node{node("web1", "failed", "b")}, []string{}, []string{"web1:a"}},
+		{"new failure after the throttle", time.Hour, []model.Node{node("web1", "failed", "b")}, []string{"web1"}, []string{"web1:b"}},
+		{"resolved while throttled", time.Hour + time.Minute, []model.Node{node("web1", "changed", "c")}, []string{}, []string{"web1:b"}},
+		{"resolved after the throttle", 2 * time.Hour, []model.Node{node("web1", "changed", "c")}, []string{}, []string{}},
+		{"failed again", 2*time.Hour + time.Minute, []model.Node{node("web1", "failed", "d")}, []string{"web1"}, []string{"web1:d"}},
+		{
+			"batched",
+			2*time.Hour + 2*time.Minute,
+			[]model.Node{node("web1", "failed", "d"), node("web2", "failed", "e"), node("web3", "failed", "f"), node("web4", "failed", "g")},
+			[]string{"summary:3"},
+			[]string{"web1:d", "web2:e", "web3:f", "web4:g"},
+		},
+	}
+
+	for _, step := range steps {
+		mu.Lock()
+		nodes = step.nodes
+		mu.Unlock()
+
+		r.check(context.Background(), testNow.Add(step.after))
+
+		if sent := notifier.take(); !reflect.DeepEqual(sent, step.sent) {
+			t.Errorf("%s: sent = %v, want %v", step.name, sent, step.sent)
+		}
+
+		states, err := r.store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := []string{}
+		for _, state := range states {
+			keys = append(keys, state.Certname+":"+state.Key)
+		}
+		slices.SortFunc(keys, cmp.Compare)
+		if !reflect.DeepEqual(keys, step.states) {
+			t.Errorf("%s: states = %v, want %v", step.name, keys, step.states)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/sebastianrakel/openvoxview/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	BACKEND_MEMORY = "memory"
+	BACKEND_FILE   = "file"
+)
+
+// StateStore keeps the last notification per rule, channel and node, with the file
+// backend it survives restarts, so nothing is notified twice
+type StateStore interface {
+	Get(id string) (model.AlertState, bool, error)
+	Put(state model.AlertState) error
+	List() ([]model.AlertState, error)
+	Delete(id string) error
+	Close() error
+}
+
+func NewStateStore(backend string, path string) (StateStore, error) {
+	switch backend {
+	case BACKEND_MEMORY, "":
+		slog.Warn("alerting uses the memory backend, notifications are sent again after a restart")
+		return NewMemoryStateStore(), nil
+	case BACKEND_FILE:
+		return NewFileStateStore(path)
+	default:
+		return nil, fmt.Errorf("unknown alerting backend %q", backend)
+	}
+}
+
+type memoryStateStore struct {
+	mu     sync.Mutex
+	states map[string]model.AlertState
+}
+
+func NewMemoryStateStore() StateStore {
+	return &memoryStateStore{
+		states: map[string]model.AlertState{},
+	}
+}
+
+func (s *memoryStateStore) Get(id string) (model.AlertState, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, found := s.states[id]
+	return state, found, nil
+}
+
+func (s *memoryStateStore) Put(state model.AlertState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[state.Id()] = state
+	return nil
+}
+
+func (s *memoryStateStore) List() ([]model.AlertState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make([]model.AlertState, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (s *memoryStateStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.states, id)
+	return nil
+}
+
+func (s *memoryStateStore) Close() error {
+	return nil
+}
+
+var statesBucket = []byte("states")
+
+// fileStateStore keeps the states in a bbolt database, keyed by their id
+type fileStateStore struct {
+	db *bolt.DB
+}
+
+func NewFileStateStore(path string) (StateStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(statesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &fileStateStore{
+		db: db,
+	}, nil
+}
+
+func (s *fileStateStore) Get(id string) (model.AlertState, bool, error) {
+	var state model.AlertState
+	found := false
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(statesBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(data, &state)
+	})
+
+	return state, found, err
+}
+
+func (s *fileStateStore) Put(state model.AlertState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(statesBucket).Put([]byte(state.Id()), data)
+	})
+}
+
+func (s *fileStateStore) List() ([]model.AlertState, error) {
+	states := []model.AlertState{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(statesBucket).ForEach(func(_, data []byte) error {
+			var state model.AlertState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return err
+			}
+			states = append(states, state)
+			return nil
+		})
+	})
+
+	return states, err
+}
+
+func (s *fileStateStore) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(statesBucket).Delete([]byte(id))
+	})
+}
+
+func (s *fileStateStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+const (
+	BACKEND_MEMORY = "memory"
+	BACKEND_FILE   = "file"
+)
+
+// Store is an append-only store of audit events
+type Store interface {
+	Append(event model.AuditEvent) (model.AuditEvent, error)
+	// Query returns the matching events, newest first, and the total count of matching events
+	Query(query model.AuditEventQuery) ([]model.AuditEvent, int, error)
+	Close() error
+}
+
+// Sink receives a copy of every recorded event, e.g. to forward it to syslog
+type Sink interface {
+	Write(event model.AuditEvent) error
+	Close() error
+}
+
+type Logger struct {
+	store Store
+	sinks []Sink
+}
+
+func NewLogger(cfg *config.Config) (*Logger, error) {
+	l := &Logger{}
+
+	switch cfg.Audit.Backend {
+	case BACKEND_MEMORY, "":
+		slog.Warn("audit log uses the memory backend, events are lost on restart", "max_entries", cfg.Audit.MaxEntries)
+		l.store = NewMemoryStore(cfg.Audit.MaxEntries)
+	case BACKEND_FILE:
+		store, err := NewFileStore(cfg.Audit.Path)
+		if err != nil {
+			return nil, err
+		}
+		l.store = store
+	default:
+		return nil, fmt.Errorf("unknown audit backend %q", cfg.Audit.Backend)
+	}
+
+	if cfg.Audit.JsonlFile != "" {
+		sink, err := NewJsonlSink(cfg.Audit.JsonlFile)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.sinks = append(l.sinks, sink)
+	}
+
+	if cfg.Audit.Syslog.Enabled {
+		sink, err := NewSyslogSink(cfg.Audit.Syslog.Network, cfg.Audit.Syslog.Address, cfg.Audit.Syslog.Tag)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.sinks = append(l.sinks, sink)
+	}
+
+	return l, nil
+}
+
+// Record stores the event and forwards it to all sinks. Errors are only logged,
+// as the audited action already happened.
+func (l *Logger) Record(event model.AuditEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	event, err := l.store.Append(event)
+	if err != nil {
+		slog.Error("error storing audit event", "error", err)
+	}
+
+	slog.Info("audit",
+		slog.Uint64("id", event.Id),
+		slog.String("user", event.User),
+		slog.String("action", event.Action),
+		slog.String("certname", event.Certname),
+		slog.String("outcome", event.Outcome),
+	)
+
+	for _, sink := range l.sinks {
+		if err := sink.Write(event); err != nil {
+			slog.Error("error forwarding audit event", "error", err)
+		}
+	}
+}
+
+func (l *Logger) Query(query model.AuditEventQuery) ([]model.AuditEvent, int, error) {
+	return l.store.Query(query)
+}
+
+func (l *Logger) Close() error {
+	for _, sink := range l.sinks {
+		sink.Close()
+	}
+
+	if l.store != nil {
+		return l.store.Close()
+	}
+
+	return nil
+}
+
+// page returns the requested page of the events
+func page(events []model.AuditEvent, query model.AuditEventQuery) []model.AuditEvent {
+	if query.Offset >= len(events) {
+		return []model.AuditEvent{}
+	}
+
+	events = events[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(events) {
+		events = events[:query.Limit]
+	}
+
+	return events
+}
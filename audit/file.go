@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/sebastianrakel/openvoxview/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+var eventsBucket = []byte("events")
+
+// fileStore keeps all events in a bbolt database, keyed by their big endian id. Events
+// are never removed, unlike with the memory store there is no maximum.
+type fileStore struct {
+	db *bolt.DB
+}
+
+func NewFileStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(eventsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &fileStore{
+		db: db,
+	}, nil
+}
+
+func (s *fileStore) Append(event model.AuditEvent) (model.AuditEvent, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(eventsBucket)
+
+		var err error
+		event.Id, err = bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, event.Id)
+
+		return bucket.Put(key, data)
+	})
+
+	return event, err
+}
+
+func (s *fileStore) Query(query model.AuditEventQuery) ([]model.AuditEvent, int, error) {
+	events := []model.AuditEvent{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(eventsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var event model.AuditEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+
+			if query.Matches(event) {
+				events = append(events, event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return page(events, query), len(events), nil
+}
+
+func (s *fileStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"sync"
+
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+// memoryStore keeps the last maxEntries events
+type memoryStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	lastId     uint64
+	events     []model.AuditEvent
+}
+
+func NewMemoryStore(maxEntries int) Store {
+	return &memoryStore{
+		maxEntries: maxEntries,
+	}
+}
+
+func (s *memoryStore) Append(event model.AuditEvent) (model.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastId++
+	event.Id = s.lastId
+
+	s.events = append(s.events, event)
+	if s.maxEntries > 0 && len(s.events) > s.maxEntries {
+		s.events = s.events[len(s.events)-s.maxEntries:]
+	}
+
+	return event, nil
+}
+
+func (s *memoryStore) Query(query model.AuditEventQuery) ([]model.AuditEvent, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []model.AuditEvent{}
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if query.Matches(s.events[i]) {
+			events = append(events, s.events[i])
+		}
+	}
+
+	return page(events, query), len(events), nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
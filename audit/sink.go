@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+	"os"
+	"sync"
+
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+type jsonlSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewJsonlSink appends every event as one json line to the given file
+func NewJsonlSink(path string) (Sink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonlSink{
+		file: file,
+	}, nil
+}
+
+func (s *jsonlSink) Write(event model.AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+func (s *jsonlSink) Close() error {
+	return s.file.Close()
+}
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogSink sends every event as json to syslog, an empty network and address
+// uses the local syslog daemon
+func NewSyslogSink(network string, address string, tag string) (Sink, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syslogSink{
+		writer: writer,
+	}, nil
+}
+
+func (s *syslogSink) Write(event model.AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return s.writer.Info(string(data))
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianrakel/openvoxview/config"
+)
+
+const (
+	PROVIDER_STATIC = "static"
+	PROVIDER_OIDC   = "oidc"
+	PROVIDER_PROXY  = "proxy"
+
+	contextKeyUser = "auth_user"
+)
+
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+type User struct {
+	Name     string
+	Email    string
+	Groups   []string
+	Roles    []string
+	Provider string
+}
+
+// Authenticator resolves the user of a request from the session cookie, http basic
+// credentials of the static users or the headers of a trusted reverse proxy.
+type Authenticator struct {
+	config   *config.Config
+	sessions *sessionCodec
+	static   *htpasswd
+	oidc     *oidcProvider
+	proxy    *proxyAuth
+}
+
+func NewAuthenticator(cfg *config.Config) (*Authenticator, error) {
+	a := &Authenticator{
+		config: cfg,
+	}
+
+	if !cfg.Auth.Enabled() {
+		return a, nil
+	}
+
+	if err := a.validateRoles(); err != nil {
+		return nil, err
+	}
+
+	sessions, err := newSessionCodec(cfg.Auth.SessionSecret, cfg.Auth.SessionLifetime)
+	if err != nil {
+		return nil, err
+	}
+	a.sessions = sessions
+
+	if cfg.Auth.StaticEnabled() {
+		a.static = newHtpasswd(cfg.Auth.Static.HtpasswdFile)
+		if err := a.static.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Auth.OIDCEnabled() {
+		a.oidc, err = newOidcProvider(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Auth.ProxyEnabled() {
+		a.proxy, err = newProxyAuth(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+func (a *Authenticator) Enabled() bool {
+	return a.config.Auth.Enabled()
+}
+
+func (a *Authenticator) Providers() []string {
+	providers := []string{}
+	if a.static != nil {
+		providers = append(providers, PROVIDER_STATIC)
+	}
+	if a.oidc != nil {
+		providers = append(providers, PROVIDER_OIDC)
+	}
+	if a.proxy != nil {
+		providers = append(providers, PROVIDER_PROXY)
+	}
+	return providers
+}
+
+// Authenticate returns the user of the request or nil if the request is anonymous.
+// The roles are resolved on every request, so changed role mappings apply immediately.
+func (a *Authenticator) Authenticate(c *gin.Context) *User {
+	user := a.authenticate(c)
+	if user != nil {
+		user.Roles = a.Roles(user)
+	}
+
+	return user
+}
+
+func (a *Authenticator) authenticate(c *gin.Context) *User {
+	if a.proxy != nil {
+		if user := a.proxy.authenticate(c); user != nil {
+			return user
+		}
+	}
+
+	if user := a.sessions.read(c.Request); user != nil {
+		return user
+	}
+
+	if a.static != nil {
+		if username, password, ok := c.Request.BasicAuth(); ok {
+			user, err := a.static.verify(username, password)
+			if err != nil {
+				slog.Info("basic authentication failed", "username", username, "error", err)
+				return nil
+			}
+			return user
+		}
+	}
+
+	return nil
+}
+
+// Login verifies the credentials of a static user
+func (a *Authenticator) Login(username string, password string) (*User, error) {
+	if a.static == nil {
+		return nil, errors.New("static users are not configured")
+	}
+
+	user, err := a.static.verify(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Roles = a.Roles(user)
+	return user, nil
+}
+
+func (a *Authenticator) StartSession(c *gin.Context, user *User) error {
+	value, err := a.sessions.encode(user)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(c.Writer, a.cookie(sessionCookieName, value, int(a.sessions.lifetime.Seconds())))
+	return nil
+}
+
+func (a *Authenticator) EndSession(c *gin.Context) {
+	http.SetCookie(c.Writer, a.cookie(sessionCookieName, "", -1))
+}
+
+func (a *Authenticator) cookie(name string, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   a.config.Auth.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (a *Authenticator) OIDCEnabled() bool {
+	return a.oidc != nil
+}
+
+func (a *Authenticator) StaticEnabled() bool {
+	return a.static != nil
+}
+
+// SetUser stores the authenticated user in the request context
+func SetUser(c *gin.Context, user *User) {
+	c.Set(contextKeyUser, user)
+}
+
+// UserFromContext returns the authenticated user, nil when authentication is disabled
+func UserFromContext(c *gin.Context) *User {
+	value, exists := c.Get(contextKeyUser)
+	if !exists {
+		return nil
+	}
+
+	user, _ := value.(*User)
+	return user
+}
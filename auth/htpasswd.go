@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswd holds the users of an apache htpasswd style file. Only bcrypt and {SHA}
+// hashes are supported, the file is reloaded when its modification time changes.
+// An optional third column contains the comma separated groups of the user:
+//
+//	admin:$2y$10$...:ca-operator,analyst
+type htpasswd struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	users   map[string]htpasswdEntry
+}
+
+type htpasswdEntry struct {
+	hash   string
+	groups []string
+}
+
+func newHtpasswd(path string) *htpasswd {
+	return &htpasswd{
+		path:  path,
+		users: map[string]htpasswdEntry{},
+	}
+}
+
+func (h *htpasswd) load() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	info, err := os.Stat(h.path)
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(h.modTime) {
+		return nil
+	}
+
+	file, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	users := map[string]htpasswdEntry{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 2 {
+			return fmt.Errorf("%s:%d: invalid entry", h.path, lineNumber)
+		}
+
+		entry := htpasswdEntry{
+			hash: fields[1],
+		}
+
+		if len(fields) == 3 && fields[2] != "" {
+			for _, group := range strings.Split(fields[2], ",") {
+				entry.groups = append(entry.groups, strings.TrimSpace(group))
+			}
+		}
+
+		users[fields[0]] = entry
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	slog.Debug("loaded htpasswd file", "path", h.path, "users", len(users))
+
+	h.users = users
+	h.modTime = info.ModTime()
+	return nil
+}
+
+func (h *htpasswd) verify(username string, password string) (*User, error) {
+	if err := h.load(); err != nil {
+		slog.Error("error loading htpasswd file", "path", h.path, "error", err)
+	}
+
+	h.mu.Lock()
+	entry, exists := h.users[username]
+	h.mu.Unlock()
+
+	if !exists || !checkPassword(entry.hash, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &User{
+		Name:     username,
+		Groups:   entry.groups,
+		Provider: PROVIDER_STATIC,
+	}, nil
+}
+
+func checkPassword(hash string, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(hash, "{SHA}")), []byte(expected)) == 1
+	default:
+		return false
+	}
+}
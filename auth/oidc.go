@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianrakel/openvoxview/config"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookieName    = "openvoxview_oidc_state"
+	oidcNonceCookieName    = "openvoxview_oidc_nonce"
+	oidcVerifierCookieName = "openvoxview_oidc_verifier"
+	oidcCookieMaxAge       = 600
+)
+
+// oidcProvider runs the authorization code flow (with PKCE) against the configured
+// issuer. The discovery is done on first use, so openvoxview also starts when the
+// issuer is not reachable yet.
+type oidcProvider struct {
+	config *config.Config
+
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func newOidcProvider(cfg *config.Config) (*oidcProvider, error) {
+	if cfg.Auth.OIDC.ClientID == "" || cfg.Auth.OIDC.RedirectURL == "" {
+		return nil, errors.New("auth.oidc requires client_id and redirect_url")
+	}
+
+	return &oidcProvider{
+		config: cfg,
+	}, nil
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth2 != nil {
+		return p.oauth2, p.verifier, nil
+	}
+
+	cfg := p.config.Auth.OIDC
+
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+
+	p.oauth2 = &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       cfg.Scopes,
+	}
+	p.verifier = provider.Verifier(&oidc.Config{
+		ClientID: cfg.ClientID,
+	})
+
+	return p.oauth2, p.verifier, nil
+}
+
+func (p *oidcProvider) userFromClaims(claims map[string]any) (*User, error) {
+	cfg := p.config.Auth.OIDC
+
+	user := &User{
+		Provider: PROVIDER_OIDC,
+	}
+
+	user.Name, _ = claims[cfg.UsernameClaim].(string)
+	if user.Name == "" {
+		user.Name, _ = claims["sub"].(string)
+	}
+	if user.Name == "" {
+		return nil, errors.New("id token contains no username")
+	}
+
+	user.Email, _ = claims["email"].(string)
+
+	switch groups := claims[cfg.GroupsClaim].(type) {
+	case []any:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				user.Groups = append(user.Groups, name)
+			}
+		}
+	case string:
+		user.Groups = []string{groups}
+	}
+
+	return user, nil
+}
+
+func randomString() (string, error) {
+	data := make([]byte, 24)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// OIDCLoginURL prepares the state cookies and returns the url of the issuer login page
+func (a *Authenticator) OIDCLoginURL(c *gin.Context) (string, error) {
+	if a.oidc == nil {
+		return "", errors.New("oidc is not configured")
+	}
+
+	oauth2Config, _, err := a.oidc.discover(c.Request.Context())
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	http.SetCookie(c.Writer, a.cookie(oidcStateCookieName, state, oidcCookieMaxAge))
+	http.SetCookie(c.Writer, a.cookie(oidcNonceCookieName, nonce, oidcCookieMaxAge))
+	http.SetCookie(c.Writer, a.cookie(oidcVerifierCookieName, verifier, oidcCookieMaxAge))
+
+	return oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// OIDCCallback validates the authorization response and returns the logged in user
+func (a *Authenticator) OIDCCallback(c *gin.Context) (*User, error) {
+	if a.oidc == nil {
+		return nil, errors.New("oidc is not configured")
+	}
+
+	if errorCode := c.Query("error"); errorCode != "" {
+		return nil, fmt.Errorf("oidc login failed: %s %s", errorCode, c.Query("error_description"))
+	}
+
+	state, err := c.Cookie(oidcStateCookieName)
+	if err != nil || state == "" || state != c.Query("state") {
+		return nil, errors.New("invalid oidc state")
+	}
+
+	nonce, err := c.Cookie(oidcNonceCookieName)
+	if err != nil {
+		return nil, errors.New("missing oidc nonce")
+	}
+
+	verifier, err := c.Cookie(oidcVerifierCookieName)
+	if err != nil {
+		return nil, errors.New("missing oidc verifier")
+	}
+
+	for _, name := range []string{oidcStateCookieName, oidcNonceCookieName, oidcVerifierCookieName} {
+		http.SetCookie(c.Writer, a.cookie(name, "", -1))
+	}
+
+	ctx := c.Request.Context()
+	oauth2Config, idTokenVerifier, err := a.oidc.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauth2Config.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("oidc code exchange failed: %w", err)
+	}
+
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("oidc token response contains no id_token")
+	}
+
+	idToken, err := idTokenVerifier.Verify(ctx, rawIdToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, errors.New("invalid oidc nonce")
+	}
+
+	claims := map[string]any{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	user, err := a.oidc.userFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Roles = a.Roles(user)
+	return user, nil
+}
@@ -0,0 +1,381 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianrakel/openvoxview/config"
+)
+
+const (
+	testClientID = "openvoxview"
+	testKeyID    = "test-key"
+)
+
+// mockIssuer is a minimal OIDC issuer with discovery, jwks and a token endpoint, the
+// id token it issues is controlled by the test
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// nonce is put into the next id token, challenge is the PKCE challenge of the login
+	nonce     string
+	challenge string
+	claims    map[string]any
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                m.server.URL,
+			"authorization_endpoint":                m.server.URL + "/authorize",
+			"token_endpoint":                        m.server.URL + "/token",
+			"jwks_uri":                              m.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": testKeyID,
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		// the handler runs outside of the test goroutine, so errors must not stop the test
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(verifier[:]) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		idToken, err := m.idToken()
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     idToken,
+		})
+	})
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	return m
+}
+
+func writeJSON(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func (m *mockIssuer) idToken() (string, error) {
+	claims := map[string]any{
+		"iss":   m.server.URL,
+		"aud":   testClientID,
+		"sub":   "user-1",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": m.nonce,
+	}
+	for name, value := range m.claims {
+		claims[name] = value
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": testKeyID, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func newTestAuthenticator(t *testing.T, issuer string) *Authenticator {
+	cfg := &config.Config{}
+	cfg.Auth.OIDC.Issuer = issuer
+	cfg.Auth.OIDC.ClientID = testClientID
+	cfg.Auth.OIDC.RedirectURL = "http://openvoxview.test/api/v1/auth/oidc/callback"
+	cfg.Auth.OIDC.Scopes = []string{"openid", "profile"}
+	cfg.Auth.OIDC.UsernameClaim = "preferred_username"
+	cfg.Auth.OIDC.GroupsClaim = "groups"
+	cfg.Auth.DefaultRoles = []string{ROLE_VIEWER}
+	cfg.Auth.RoleMappings = []config.ConfigRoleMapping{{Group: "admins", Roles: []string{ROLE_ADMIN}}}
+	cfg.Auth.SessionSecret = "secret"
+	cfg.Auth.SessionLifetime = time.Hour
+
+	a, err := NewAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func testContext(target string, cookies []*http.Cookie) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	for _, cookie := range cookies {
+		c.Request.AddCookie(cookie)
+	}
+
+	return c, w
+}
+
+func cookieValue(cookies []*http.Cookie, name string) string {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// login starts the flow and returns the cookies set for the callback and the state
+// the issuer would send back
+func login(t *testing.T, a *Authenticator, issuer *mockIssuer) ([]*http.Cookie, string) {
+	c, w := testContext("/api/v1/auth/oidc/login", nil)
+
+	loginURL, err := a.OIDCLoginURL(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loginURL, issuer.server.URL+"/authorize") {
+		t.Fatalf("login url %s does not point to the issuer", loginURL)
+	}
+
+	params := parsed.Query()
+	if params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == "" {
+		t.Fatalf("login url %s has no PKCE challenge", loginURL)
+	}
+
+	cookies := w.Result().Cookies()
+	if params.Get("state") != cookieValue(cookies, oidcStateCookieName) {
+		t.Fatalf("state of login url and cookie differ")
+	}
+	if params.Get("nonce") != cookieValue(cookies, oidcNonceCookieName) {
+		t.Fatalf("nonce of login url and cookie differ")
+	}
+
+	issuer.nonce = params.Get("nonce")
+	issuer.challenge = params.Get("code_challenge")
+
+	return cookies, params.Get("state")
+}
+
+func TestOIDCCallback(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.claims = map[string]any{
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"groups":             []string{"admins"},
+	}
+
+	a := newTestAuthenticator(t, issuer.server.URL)
+	cookies, state := login(t, a, issuer)
+
+	c, _ := testContext("/api/v1/auth/oidc/callback?code=code&state="+url.QueryEscape(state), cookies)
+	user, err := a.OIDCCallback(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Name != "alice" || user.Email != "alice@example.com" || user.Provider != PROVIDER_OIDC {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if strings.Join(user.Roles, ",") != "admin,viewer" {
+		t.Errorf("roles = %v, want [admin viewer]", user.Roles)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare changes the callback request of a valid login
+		prepare func(issuer *mockIssuer, cookies []*http.Cookie, state string) ([]*http.Cookie, string)
+		err     string
+	}{
+		{
+			name: "state mismatch",
+			prepare: func(issuer *mockIssuer, cookies []*http.Cookie, state string) ([]*http.Cookie, string) {
+				return cookies, state + "x"
+			},
+			err: "invalid oidc state",
+		},
+		{
+			name: "missing state cookie",
+			prepare: func(issuer *mockIssuer, cookies []*http.Cookie, state string) ([]*http.Cookie, string) {
+				return withoutCookie(cookies, oidcStateCookieName), state
+			},
+			err: "invalid oidc state",
+		},
+		{
+			name: "missing nonce cookie",
+			prepare: func(issuer *mockIssuer, cookies []*http.Cookie, state string) ([]*http.Cookie, string) {
+				return withoutCookie(cookies, oidcNonceCookieName), state
+			},
+			err: "missing oidc nonce",
+		},
+		{
+			name: "nonce mismatch",
+			prepare: func(issuer *mockIssuer, cookies []*http.Cookie, state string) ([]*http.Cookie, string) {
+				issuer.nonce = "other"
+				return cookies, state
+			},
+			err: "invalid oidc nonce",
+		},
+		{
+			name: "wrong PKCE verifier",
+			prepare: func(issuer *mockIssuer, cookies []*http.Cookie, state string) ([]*http.Cookie, string) {
+				issuer.challenge = "other"
+				return cookies, state
+			},
+			err: "oidc code exchange failed",
+		},
+		{
+			name: "token of another audience",
+			prepare: func(issuer *mockIssuer, cookies []*http.Cookie, state string) ([]*http.Cookie, string) {
+				issuer.claims["aud"] = "other-client"
+				return cookies, state
+			},
+			err: "audience",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issuer := newMockIssuer(t)
+			issuer.claims = map[string]any{"preferred_username": "alice"}
+
+			a := newTestAuthenticator(t, issuer.server.URL)
+			cookies, state := login(t, a, issuer)
+			cookies, state = test.prepare(issuer, cookies, state)
+
+			c, _ := testContext("/api/v1/auth/oidc/callback?code=code&state="+url.QueryEscape(state), cookies)
+			user, err := a.OIDCCallback(c)
+			if err == nil {
+				t.Fatalf("callback accepted, user %+v", user)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %q does not contain %q", err, test.err)
+			}
+		})
+	}
+}
+
+func withoutCookie(cookies []*http.Cookie, name string) []*http.Cookie {
+	result := []*http.Cookie{}
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			result = append(result, cookie)
+		}
+	}
+	return result
+}
+
+func TestSessionCookie(t *testing.T) {
+	issuer := newMockIssuer(t)
+	a := newTestAuthenticator(t, issuer.server.URL)
+
+	c, w := testContext("/api/v1/auth/oidc/callback", nil)
+	if err := a.StartSession(c, &User{Name: "alice", Groups: []string{"admins"}, Provider: PROVIDER_OIDC}); err != nil {
+		t.Fatal(err)
+	}
+
+	session := w.Result().Cookies()
+	if cookieValue(session, sessionCookieName) == "" {
+		t.Fatal("no session cookie set")
+	}
+
+	c, _ = testContext("/api/v1/me", session)
+	user := a.Authenticate(c)
+	if user == nil || user.Name != "alice" {
+		t.Fatalf("session not accepted, user %+v", user)
+	}
+	if strings.Join(user.Roles, ",") != "admin,viewer" {
+		t.Errorf("roles = %v, want [admin viewer]", user.Roles)
+	}
+
+	payload, signature, _ := strings.Cut(cookieValue(session, sessionCookieName), ".")
+
+	forged, _ := json.Marshal(map[string]any{
+		"User":    User{Name: "mallory", Groups: []string{"admins"}},
+		"Expires": time.Now().Add(time.Hour).Unix(),
+	})
+	expired, err := (&sessionCodec{secret: a.sessions.secret, lifetime: -time.Minute}).encode(&User{Name: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"forged payload", base64.RawURLEncoding.EncodeToString(forged) + "." + signature},
+		{"modified signature", payload + "." + signature[:len(signature)-2] + "AA"},
+		{"without signature", payload},
+		{"expired", expired},
+		{"other secret", func() string {
+			value, _ := (&sessionCodec{secret: []byte("other"), lifetime: time.Hour}).encode(&User{Name: "alice"})
+			return value
+		}()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := testContext("/api/v1/me", []*http.Cookie{{Name: sessionCookieName, Value: test.value}})
+			if user := a.Authenticate(c); user != nil {
+				t.Errorf("session accepted, user %+v", user)
+			}
+		})
+	}
+}
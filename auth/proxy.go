@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianrakel/openvoxview/config"
+)
+
+// proxyAuth trusts the user headers set by a reverse proxy, but only if the request
+// really comes from one of the configured trusted proxies
+type proxyAuth struct {
+	userHeader      string
+	groupsHeader    string
+	groupsSeparator string
+	trusted         []netip.Prefix
+}
+
+func newProxyAuth(cfg *config.Config) (*proxyAuth, error) {
+	if len(cfg.TrustedProxies) == 0 {
+		return nil, errors.New("auth.proxy requires trusted_proxies to be configured")
+	}
+
+	p := &proxyAuth{
+		userHeader:      cfg.Auth.Proxy.UserHeader,
+		groupsHeader:    cfg.Auth.Proxy.GroupsHeader,
+		groupsSeparator: cfg.Auth.Proxy.GroupsSeparator,
+	}
+
+	for _, proxy := range cfg.TrustedProxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, err
+			}
+			p.trusted = append(p.trusted, prefix)
+			continue
+		}
+
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, err
+		}
+		p.trusted = append(p.trusted, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return p, nil
+}
+
+func (p *proxyAuth) isTrusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range p.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *proxyAuth) authenticate(c *gin.Context) *User {
+	username := c.GetHeader(p.userHeader)
+	if username == "" || !p.isTrusted(c.Request.RemoteAddr) {
+		return nil
+	}
+
+	user := &User{
+		Name:     username,
+		Provider: PROVIDER_PROXY,
+	}
+
+	if p.groupsHeader != "" {
+		for _, group := range strings.Split(c.GetHeader(p.groupsHeader), p.groupsSeparator) {
+			group = strings.TrimSpace(group)
+			if group != "" {
+				user.Groups = append(user.Groups, group)
+			}
+		}
+	}
+
+	return user
+}
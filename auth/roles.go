@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+)
+
+type Permission string
+
+const (
+	// PERMISSION_VIEW allows the predefined views, dashboards and read only endpoints
+	PERMISSION_VIEW Permission = "view"
+	// PERMISSION_QUERY allows executing raw PQL queries
+	PERMISSION_QUERY Permission = "query"
+	// PERMISSION_CA_WRITE allows signing, revoking and cleaning certificates
+	PERMISSION_CA_WRITE Permission = "ca_write"
+	// PERMISSION_AUDIT allows reading the audit log
+	PERMISSION_AUDIT Permission = "audit"
+)
+
+const (
+	ROLE_VIEWER      = "viewer"
+	ROLE_ANALYST     = "analyst"
+	ROLE_CA_OPERATOR = "ca-operator"
+	ROLE_ADMIN       = "admin"
+)
+
+var RolePermissions = map[string][]Permission{
+	ROLE_VIEWER:      {PERMISSION_VIEW},
+	ROLE_ANALYST:     {PERMISSION_VIEW, PERMISSION_QUERY},
+	ROLE_CA_OPERATOR: {PERMISSION_VIEW, PERMISSION_CA_WRITE, PERMISSION_AUDIT},
+	ROLE_ADMIN:       {PERMISSION_VIEW, PERMISSION_QUERY, PERMISSION_CA_WRITE, PERMISSION_AUDIT},
+}
+
+var allPermissions = []Permission{PERMISSION_VIEW, PERMISSION_QUERY, PERMISSION_CA_WRITE, PERMISSION_AUDIT}
+
+func (a *Authenticator) validateRoles() error {
+	roles := slices.Clone(a.config.Auth.DefaultRoles)
+	for _, mapping := range a.config.Auth.RoleMappings {
+		if mapping.Group == "" && mapping.User == "" {
+			return fmt.Errorf("auth.role_mappings: entry with roles %v needs a group or user", mapping.Roles)
+		}
+		roles = append(roles, mapping.Roles...)
+	}
+
+	for _, role := range roles {
+		if _, exists := RolePermissions[role]; !exists {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+
+	return nil
+}
+
+// Roles returns the roles of the user based on the default roles and the role mappings
+func (a *Authenticator) Roles(user *User) []string {
+	roles := slices.Clone(a.config.Auth.DefaultRoles)
+
+	for _, mapping := range a.config.Auth.RoleMappings {
+		if (mapping.User != "" && mapping.User == user.Name) ||
+			(mapping.Group != "" && slices.Contains(user.Groups, mapping.Group)) {
+			roles = append(roles, mapping.Roles...)
+		}
+	}
+
+	slices.Sort(roles)
+	return slices.Compact(roles)
+}
+
+// Permissions returns the permissions of the user, without authentication everybody
+// has all permissions
+func (a *Authenticator) Permissions(user *User) []Permission {
+	if !a.Enabled() {
+		return slices.Clone(allPermissions)
+	}
+
+	if user == nil {
+		return []Permission{}
+	}
+
+	permissions := []Permission{}
+	for _, role := range user.Roles {
+		for _, permission := range RolePermissions[role] {
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+
+	return permissions
+}
+
+func (a *Authenticator) HasPermission(user *User, permission Permission) bool {
+	return slices.Contains(a.Permissions(user), permission)
+}
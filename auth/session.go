@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const sessionCookieName = "openvoxview_session"
+
+type session struct {
+	User    User
+	Expires int64
+}
+
+// sessionCodec stores the session in a hmac signed cookie, so no server side state is needed
+type sessionCodec struct {
+	secret   []byte
+	lifetime time.Duration
+}
+
+func newSessionCodec(secret string, lifetime time.Duration) (*sessionCodec, error) {
+	key := []byte(secret)
+
+	if secret == "" {
+		slog.Warn("no auth.session_secret configured, sessions will not survive a restart")
+
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &sessionCodec{
+		secret:   key,
+		lifetime: lifetime,
+	}, nil
+}
+
+func (s *sessionCodec) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *sessionCodec) encode(user *User) (string, error) {
+	data, err := json.Marshal(session{
+		User:    *user,
+		Expires: time.Now().Add(s.lifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.sign(payload), nil
+}
+
+func (s *sessionCodec) decode(value string) (*User, error) {
+	payload, signature, found := strings.Cut(value, ".")
+	if !found {
+		return nil, errors.New("malformed session")
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return nil, errors.New("invalid session signature")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var sess session
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return nil, err
+	}
+
+	if time.Now().Unix() > sess.Expires {
+		return nil, errors.New("session expired")
+	}
+
+	return &sess.User, nil
+}
+
+func (s *sessionCodec) read(r *http.Request) *User {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+
+	user, err := s.decode(cookie.Value)
+	if err != nil {
+		slog.Debug("ignoring session cookie", "error", err)
+		return nil
+	}
+
+	return user
+}
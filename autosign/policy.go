@@ -0,0 +1,186 @@
+package autosign
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+type extensionRule struct {
+	name  string
+	value *regexp.Regexp
+}
+
+type policy struct {
+	name                string
+	certname            *regexp.Regexp
+	requiredExtensions  []extensionRule
+	forbiddenExtensions []string
+	forbiddenAltNames   []*regexp.Regexp
+}
+
+// Evaluator decides with the configured policies which certificate requests may be signed
+type Evaluator struct {
+	policies []policy
+}
+
+// compileFull compiles the pattern so it has to match the whole value, e.g. a certname
+// pattern web\d+ does not match evilweb1.example.com
+func compileFull(pattern string) (*regexp.Regexp, error) {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, err
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// source returns the configured pattern of a regex compiled by compileFull
+func source(re *regexp.Regexp) string {
+	return strings.TrimSuffix(strings.TrimPrefix(re.String(), "^(?:"), ")$")
+}
+
+// NewEvaluator compiles the policies, every policy needs a certname or a required
+// extension, as a policy without them would sign every request
+func NewEvaluator(policies []config.ConfigAutosignPolicy) (*Evaluator, error) {
+	e := &Evaluator{}
+
+	for i, cfg := range policies {
+		p := policy{
+			name:                cfg.Name,
+			forbiddenExtensions: cfg.ForbiddenExtensions,
+		}
+
+		if p.name == "" {
+			p.name = fmt.Sprintf("policy %d", i+1)
+		}
+
+		if cfg.Certname == "" && len(cfg.RequiredExtensions) == 0 {
+			return nil, fmt.Errorf("autosign %s: needs a certname or required_extensions, it would sign every request", p.name)
+		}
+
+		var err error
+		if cfg.Certname != "" {
+			p.certname, err = compileFull(cfg.Certname)
+			if err != nil {
+				return nil, fmt.Errorf("autosign %s: certname: %w", p.name, err)
+			}
+		}
+
+		for _, extension := range cfg.RequiredExtensions {
+			rule := extensionRule{
+				name: extension.Name,
+			}
+
+			if extension.Value != "" {
+				rule.value, err = compileFull(extension.Value)
+				if err != nil {
+					return nil, fmt.Errorf("autosign %s: extension %s: %w", p.name, extension.Name, err)
+				}
+			}
+
+			p.requiredExtensions = append(p.requiredExtensions, rule)
+		}
+
+		for _, altName := range cfg.ForbiddenAltNames {
+			re, err := compileFull(altName)
+			if err != nil {
+				return nil, fmt.Errorf("autosign %s: forbidden alt name: %w", p.name, err)
+			}
+			p.forbiddenAltNames = append(p.forbiddenAltNames, re)
+		}
+
+		e.policies = append(e.policies, p)
+	}
+
+	return e, nil
+}
+
+// check returns why the request does not match the policy, nothing if it matches
+func (p *policy) check(cert model.CertificateStatus) []string {
+	reasons := []string{}
+
+	if p.certname != nil && !p.certname.MatchString(cert.Name) {
+		reasons = append(reasons, fmt.Sprintf("certname does not match %q", source(p.certname)))
+	}
+
+	extensions := map[string]string{}
+	if cert.AuthorizationExtensions != nil {
+		extensions = *cert.AuthorizationExtensions
+	}
+
+	for _, rule := range p.requiredExtensions {
+		value, exists := extensions[rule.name]
+		switch {
+		case !exists:
+			reasons = append(reasons, fmt.Sprintf("extension %s is missing", rule.name))
+		case rule.value != nil && !rule.value.MatchString(value):
+			reasons = append(reasons, fmt.Sprintf("extension %s=%q does not match %q", rule.name, value, source(rule.value)))
+		}
+	}
+
+	for _, name := range p.forbiddenExtensions {
+		if _, exists := extensions[name]; exists {
+			reasons = append(reasons, fmt.Sprintf("extension %s is forbidden", name))
+		}
+	}
+
+	for _, altName := range altNames(cert) {
+		for _, re := range p.forbiddenAltNames {
+			if re.MatchString(altName) {
+				reasons = append(reasons, fmt.Sprintf("alt name %s is forbidden by %q", altName, source(re)))
+			}
+		}
+	}
+
+	return reasons
+}
+
+func altNames(cert model.CertificateStatus) []string {
+	names := slices.Clone(cert.DnsAltNames)
+	if cert.SubjectAltNames != nil {
+		for _, name := range *cert.SubjectAltNames {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// Evaluate decides for a certificate request, the first matching policy wins
+func (e *Evaluator) Evaluate(cert model.CertificateStatus) model.AutosignDecision {
+	decision := model.AutosignDecision{
+		Certname:    cert.Name,
+		Fingerprint: cert.Fingerprint,
+		Reasons:     []string{},
+	}
+
+	if cert.State != model.CertificateRequested {
+		decision.Reasons = append(decision.Reasons, fmt.Sprintf("certificate is %s", cert.State))
+		return decision
+	}
+
+	if len(e.policies) == 0 {
+		decision.Reasons = append(decision.Reasons, "no autosign policies configured")
+		return decision
+	}
+
+	for _, p := range e.policies {
+		reasons := p.check(cert)
+		if len(reasons) == 0 {
+			decision.Sign = true
+			decision.Policy = p.name
+			decision.Reasons = []string{fmt.Sprintf("matches %s", p.name)}
+			return decision
+		}
+
+		for _, reason := range reasons {
+			decision.Reasons = append(decision.Reasons, fmt.Sprintf("%s: %s", p.name, reason))
+		}
+	}
+
+	return decision
+}
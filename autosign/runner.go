@@ -0,0 +1,108 @@
+package autosign
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sebastianrakel/openvoxview/audit"
+	"github.com/sebastianrakel/openvoxview/model"
+	"github.com/sebastianrakel/openvoxview/puppetca"
+)
+
+// AUDIT_USER is recorded as user of the automatically signed certificates
+const AUDIT_USER = "autosign"
+
+// Runner periodically signs the pending certificate requests matching a policy
+type Runner struct {
+	caClient    *puppetca.Client
+	evaluator   *Evaluator
+	auditLogger *audit.Logger
+	interval    time.Duration
+}
+
+func NewRunner(caClient *puppetca.Client, evaluator *Evaluator, auditLogger *audit.Logger, interval time.Duration) (*Runner, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("autosign interval must be positive, got %s", interval)
+	}
+
+	return &Runner{
+		caClient:    caClient,
+		evaluator:   evaluator,
+		auditLogger: auditLogger,
+		interval:    interval,
+	}, nil
+}
+
+// DryRun evaluates all pending certificate requests without signing them
+func (r *Runner) DryRun() ([]model.AutosignDecision, error) {
+	state := model.CertificateRequested
+	certs, err := r.caClient.GetCertificates(&state)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := make([]model.AutosignDecision, 0, len(certs))
+	for _, cert := range certs {
+		decisions = append(decisions, r.evaluator.Evaluate(cert))
+	}
+
+	return decisions, nil
+}
+
+func (r *Runner) Run(ctx context.Context) {
+	slog.Info("autosign started", "interval", r.interval)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.signPending()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) signPending() {
+	state := model.CertificateRequested
+	certs, err := r.caClient.GetCertificates(&state)
+	if err != nil {
+		slog.Error("autosign: error getting certificate requests", "error", err)
+		return
+	}
+
+	for _, cert := range certs {
+		decision := r.evaluator.Evaluate(cert)
+		if !decision.Sign {
+			slog.Debug("autosign: not signing", "certname", cert.Name, "reasons", decision.Reasons)
+			continue
+		}
+
+		slog.Info("autosign: signing", "certname", cert.Name, "policy", decision.Policy)
+
+		err := r.caClient.SignCertificate(cert.Name)
+
+		event := model.AuditEvent{
+			User:             AUDIT_USER,
+			Action:           model.AuditActionSign,
+			Certname:         cert.Name,
+			PriorState:       &cert.State,
+			PriorFingerprint: cert.Fingerprint,
+			Outcome:          model.AuditOutcomeSuccess,
+			Reason:           decision.Policy,
+		}
+
+		if err != nil {
+			slog.Error("autosign: error signing certificate", "certname", cert.Name, "error", err)
+			event.Outcome = model.AuditOutcomeFailure
+			event.Error = err.Error()
+		}
+
+		r.auditLogger.Record(event)
+	}
+}
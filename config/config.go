@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/sebastianrakel/openvoxview/model"
 	"github.com/spf13/viper"
@@ -26,8 +27,12 @@ const (
 var configPath = flag.String("config", "", "path to the config file")
 var printVersion = flag.Bool("version", false, "prints version")
 
-func init() {
-	flag.Parse()
+// parseFlags parses the command line on first use instead of in init, so packages
+// importing the config can be tested with the flags of go test
+func parseFlags() {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
 }
 
 type ConfigPqlQuery struct {
@@ -47,6 +52,11 @@ type Config struct {
 		TLS_CA    string `mapstructure:"tls_ca"`
 		TLS_KEY   string `mapstructure:"tls_key"`
 		TLS_CERT  string `mapstructure:"tls_cert"`
+
+		Timeout         time.Duration `mapstructure:"timeout"`
+		IdleConnTimeout time.Duration `mapstructure:"idle_conn_timeout"`
+		MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+		StreamMaxRows   int           `mapstructure:"stream_max_rows"`
 	} `mapstructure:"puppetdb"`
 	PqlQueries                        []ConfigPqlQuery `mapstructure:"queries"`
 	Views                             []model.View     `mapstructure:"views"`
@@ -54,21 +64,167 @@ type Config struct {
 	StripPathPrefix                   string           `mapstructure:"strip_path_prefix"`
 	UiDefaultRefreshIntervalInSeconds uint             `mapstructure:"ui_default_refresh_interval_in_seconds"`
 	PuppetCA                          struct {
-		Host            string `mapstructure:"host"`
-		Port            uint64 `mapstructure:"port"`
-		TLS             bool   `mapstructure:"tls"`
-		TLSIgnore       bool   `mapstructure:"tls_ignore"`
-		TLS_CA          string `mapstructure:"tls_ca"`
-		TLS_KEY         string `mapstructure:"tls_key"`
-		TLS_CERT        string `mapstructure:"tls_cert"`
-		ReadOnly        bool   `mapstructure:"readonly"`
-		DeactivateNodes bool   `mapstructure:"deactivate_nodes"`
+		Host                string `mapstructure:"host"`
+		Port                uint64 `mapstructure:"port"`
+		TLS                 bool   `mapstructure:"tls"`
+		TLSIgnore           bool   `mapstructure:"tls_ignore"`
+		TLS_CA              string `mapstructure:"tls_ca"`
+		TLS_KEY             string `mapstructure:"tls_key"`
+		TLS_CERT            string `mapstructure:"tls_cert"`
+		ReadOnly            bool   `mapstructure:"readonly"`
+		DeactivateNodes     bool   `mapstructure:"deactivate_nodes"`
+		BulkConcurrency     int    `mapstructure:"bulk_concurrency"`
+		BulkMaxCertificates int    `mapstructure:"bulk_max_certificates"`
+		ExpiryDays          int    `mapstructure:"expiry_days"`
+		Autosign            struct {
+			Enabled  bool                   `mapstructure:"enabled"`
+			Interval time.Duration          `mapstructure:"interval"`
+			Policies []ConfigAutosignPolicy `mapstructure:"policies"`
+		} `mapstructure:"autosign"`
 	} `mapstructure:"puppetca"`
-	LogLevel  LogLevel  `mapstructure:"log_level"`
-	LogFormat LogFormat `mapstructure:"log_format"`
+	QueryHistory struct {
+		Backend    string `mapstructure:"backend"`
+		Path       string `mapstructure:"path"`
+		MaxEntries int    `mapstructure:"max_entries"`
+		MaxPinned  int    `mapstructure:"max_pinned"`
+	} `mapstructure:"query_history"`
+	Cache struct {
+		Enabled    bool                     `mapstructure:"enabled"`
+		MaxEntries int                      `mapstructure:"max_entries"`
+		TTL        map[string]time.Duration `mapstructure:"ttl"`
+	} `mapstructure:"cache"`
+	QueryLimits struct {
+		Timeout        time.Duration `mapstructure:"timeout"`
+		ExportTimeout  time.Duration `mapstructure:"export_timeout"`
+		DefaultLimit   int           `mapstructure:"default_limit"`
+		MaxLimit       int           `mapstructure:"max_limit"`
+		AllowUnlimited bool          `mapstructure:"allow_unlimited"`
+		DeniedEntities []string      `mapstructure:"denied_entities"`
+		MaxConcurrent  int           `mapstructure:"max_concurrent"`
+	} `mapstructure:"query_limits"`
+	Audit struct {
+		Backend    string `mapstructure:"backend"`
+		Path       string `mapstructure:"path"`
+		MaxEntries int    `mapstructure:"max_entries"`
+		JsonlFile  string `mapstructure:"jsonl_file"`
+		Syslog     struct {
+			Enabled bool   `mapstructure:"enabled"`
+			Network string `mapstructure:"network"`
+			Address string `mapstructure:"address"`
+			Tag     string `mapstructure:"tag"`
+		} `mapstructure:"syslog"`
+	} `mapstructure:"audit"`
+	Events struct {
+		Enabled  bool          `mapstructure:"enabled"`
+		Interval time.Duration `mapstructure:"interval"`
+	} `mapstructure:"events"`
+	Alerting struct {
+		Enabled  bool                 `mapstructure:"enabled"`
+		Interval time.Duration        `mapstructure:"interval"`
+		Backend  string               `mapstructure:"backend"`
+		Path     string               `mapstructure:"path"`
+		Channels []ConfigAlertChannel `mapstructure:"channels"`
+		Rules    []ConfigAlertRule    `mapstructure:"rules"`
+	} `mapstructure:"alerting"`
+	Metrics struct {
+		Enabled             bool `mapstructure:"enabled"`
+		Public              bool `mapstructure:"public"`
+		CertificateExpiries int  `mapstructure:"certificate_expiries"`
+	} `mapstructure:"metrics"`
+	Auth      ConfigAuth `mapstructure:"auth"`
+	LogLevel  LogLevel   `mapstructure:"log_level"`
+	LogFormat LogFormat  `mapstructure:"log_format"`
+}
+
+type ConfigAutosignExtension struct {
+	Name  string `mapstructure:"name"`
+	Value string `mapstructure:"value"`
+}
+
+type ConfigAutosignPolicy struct {
+	Name                string                    `mapstructure:"name"`
+	Certname            string                    `mapstructure:"certname"`
+	RequiredExtensions  []ConfigAutosignExtension `mapstructure:"required_extensions"`
+	ForbiddenExtensions []string                  `mapstructure:"forbidden_extensions"`
+	ForbiddenAltNames   []string                  `mapstructure:"forbidden_alt_names"`
+}
+
+type ConfigAlertChannel struct {
+	Name        string            `mapstructure:"name"`
+	Type        string            `mapstructure:"type"`
+	URL         string            `mapstructure:"url"`
+	Headers     map[string]string `mapstructure:"headers"`
+	RoomID      string            `mapstructure:"room_id"`
+	AccessToken string            `mapstructure:"access_token"`
+	SMTP        struct {
+		Host     string   `mapstructure:"host"`
+		Port     uint64   `mapstructure:"port"`
+		Username string   `mapstructure:"username"`
+		Password string   `mapstructure:"password"`
+		From     string   `mapstructure:"from"`
+		To       []string `mapstructure:"to"`
+	} `mapstructure:"smtp"`
+}
+
+type ConfigAlertRule struct {
+	Name             string        `mapstructure:"name"`
+	Condition        string        `mapstructure:"condition"`
+	Environments     []string      `mapstructure:"environments"`
+	Days             int           `mapstructure:"days"`
+	Throttle         time.Duration `mapstructure:"throttle"`
+	MaxNotifications int           `mapstructure:"max_notifications"`
+	Channels         []string      `mapstructure:"channels"`
+}
+
+type ConfigRoleMapping struct {
+	Group string   `mapstructure:"group"`
+	User  string   `mapstructure:"user"`
+	Roles []string `mapstructure:"roles"`
+}
+
+type ConfigAuth struct {
+	DefaultRoles    []string            `mapstructure:"default_roles"`
+	RoleMappings    []ConfigRoleMapping `mapstructure:"role_mappings"`
+	SessionSecret   string              `mapstructure:"session_secret"`
+	SessionLifetime time.Duration       `mapstructure:"session_lifetime"`
+	CookieSecure    bool                `mapstructure:"cookie_secure"`
+	Static          struct {
+		HtpasswdFile string `mapstructure:"htpasswd_file"`
+	} `mapstructure:"static"`
+	OIDC struct {
+		Issuer        string   `mapstructure:"issuer"`
+		ClientID      string   `mapstructure:"client_id"`
+		ClientSecret  string   `mapstructure:"client_secret"`
+		RedirectURL   string   `mapstructure:"redirect_url"`
+		Scopes        []string `mapstructure:"scopes"`
+		UsernameClaim string   `mapstructure:"username_claim"`
+		GroupsClaim   string   `mapstructure:"groups_claim"`
+	} `mapstructure:"oidc"`
+	Proxy struct {
+		UserHeader      string `mapstructure:"user_header"`
+		GroupsHeader    string `mapstructure:"groups_header"`
+		GroupsSeparator string `mapstructure:"groups_separator"`
+	} `mapstructure:"proxy"`
+}
+
+func (a *ConfigAuth) StaticEnabled() bool {
+	return a.Static.HtpasswdFile != ""
+}
+
+func (a *ConfigAuth) OIDCEnabled() bool {
+	return a.OIDC.Issuer != ""
+}
+
+func (a *ConfigAuth) ProxyEnabled() bool {
+	return a.Proxy.UserHeader != ""
+}
+
+func (a *ConfigAuth) Enabled() bool {
+	return a.StaticEnabled() || a.OIDCEnabled() || a.ProxyEnabled()
 }
 
 func PrintVersion(version string) bool {
+	parseFlags()
 	if *printVersion {
 		fmt.Println(version)
 		return true
@@ -84,6 +240,8 @@ var (
 
 func GetConfig() (*Config, error) {
 	configOnce.Do(func() {
+		parseFlags()
+
 		viper.SetConfigName("config")
 		viper.SetConfigType("yaml")
 		viper.AddConfigPath(".")
@@ -97,6 +255,10 @@ func GetConfig() (*Config, error) {
 		viper.SetDefault("puppetdb.host", "localhost")
 		viper.SetDefault("puppetdb.port", 8080)
 		viper.SetDefault("puppetdb.tls_ignore", false)
+		viper.SetDefault("puppetdb.timeout", "60s")
+		viper.SetDefault("puppetdb.idle_conn_timeout", "90s")
+		viper.SetDefault("puppetdb.max_idle_conns", 10)
+		viper.SetDefault("puppetdb.stream_max_rows", 100000)
 		viper.SetDefault("unreported_hours", 3)
 		viper.SetDefault("strip_path_prefix", `/etc/puppetlabs/code/environments(/.*?/modules)?`)
 		viper.SetDefault("puppetca.port", 8140)
@@ -104,7 +266,46 @@ func GetConfig() (*Config, error) {
 		viper.SetDefault("puppetca.tls_ignore", false)
 		viper.SetDefault("puppetca.readonly", true)
 		viper.SetDefault("puppetca.deactivate_nodes", false)
+		viper.SetDefault("puppetca.bulk_concurrency", 5)
+		viper.SetDefault("puppetca.bulk_max_certificates", 50)
+		viper.SetDefault("puppetca.expiry_days", 30)
+		viper.SetDefault("puppetca.autosign.enabled", false)
+		viper.SetDefault("puppetca.autosign.interval", "60s")
 		viper.SetDefault("ui_default_refresh_interval_in_seconds", 300)
+		viper.SetDefault("query_history.backend", "memory")
+		viper.SetDefault("query_history.path", "query_history.db")
+		viper.SetDefault("query_history.max_entries", 100)
+		viper.SetDefault("query_history.max_pinned", 50)
+		viper.SetDefault("cache.enabled", true)
+		viper.SetDefault("cache.max_entries", 1000)
+		viper.SetDefault("cache.ttl.nodes", "30s")
+		viper.SetDefault("cache.ttl.event-counts", "30s")
+		viper.SetDefault("cache.ttl.fact-names", "5m")
+		viper.SetDefault("query_limits.timeout", "30s")
+		viper.SetDefault("query_limits.export_timeout", "10m")
+		viper.SetDefault("query_limits.default_limit", 1000)
+		viper.SetDefault("query_limits.allow_unlimited", true)
+		viper.SetDefault("query_limits.max_concurrent", 2)
+		viper.SetDefault("audit.backend", "file")
+		viper.SetDefault("audit.path", "audit.db")
+		viper.SetDefault("audit.max_entries", 10000)
+		viper.SetDefault("audit.syslog.enabled", false)
+		viper.SetDefault("audit.syslog.tag", "openvoxview")
+		viper.SetDefault("events.enabled", true)
+		viper.SetDefault("events.interval", "30s")
+		viper.SetDefault("alerting.enabled", false)
+		viper.SetDefault("alerting.interval", "60s")
+		viper.SetDefault("alerting.backend", "file")
+		viper.SetDefault("alerting.path", "alerting.db")
+		viper.SetDefault("metrics.enabled", true)
+		viper.SetDefault("metrics.certificate_expiries", 10)
+		viper.SetDefault("auth.default_roles", []string{"viewer"})
+		viper.SetDefault("auth.session_lifetime", "12h")
+		viper.SetDefault("auth.cookie_secure", false)
+		viper.SetDefault("auth.oidc.scopes", []string{"openid", "profile", "email"})
+		viper.SetDefault("auth.oidc.username_claim", "preferred_username")
+		viper.SetDefault("auth.oidc.groups_claim", "groups")
+		viper.SetDefault("auth.proxy.groups_separator", ",")
 		viper.SetDefault("log_level", "info")
 		viper.SetDefault("log_format", "text")
 
@@ -120,6 +321,10 @@ func GetConfig() (*Config, error) {
 		viper.BindEnv("puppetdb.tls_ca", "PUPPETDB_TLS_CA")
 		viper.BindEnv("puppetdb.tls_key", "PUPPETDB_TLS_KEY")
 		viper.BindEnv("puppetdb.tls_cert", "PUPPETDB_TLS_CERT")
+		viper.BindEnv("puppetdb.timeout", "PUPPETDB_TIMEOUT")
+		viper.BindEnv("puppetdb.idle_conn_timeout", "PUPPETDB_IDLE_CONN_TIMEOUT")
+		viper.BindEnv("puppetdb.max_idle_conns", "PUPPETDB_MAX_IDLE_CONNS")
+		viper.BindEnv("puppetdb.stream_max_rows", "PUPPETDB_STREAM_MAX_ROWS")
 		viper.BindEnv("unreported_hours", "UNREPORTED_HOURS")
 		viper.BindEnv("strip_path_prefix", "STRIP_PATH_PREFIX")
 		viper.BindEnv("puppetca.host", "PUPPETCA_HOST")
@@ -131,7 +336,53 @@ func GetConfig() (*Config, error) {
 		viper.BindEnv("puppetca.tls_cert", "PUPPETCA_TLS_CERT")
 		viper.BindEnv("puppetca.readonly", "PUPPETCA_READONLY")
 		viper.BindEnv("puppetca.deactivate_nodes", "PUPPETCA_DEACTIVATE_NODES")
+		viper.BindEnv("puppetca.bulk_concurrency", "PUPPETCA_BULK_CONCURRENCY")
+		viper.BindEnv("puppetca.bulk_max_certificates", "PUPPETCA_BULK_MAX_CERTIFICATES")
+		viper.BindEnv("puppetca.expiry_days", "PUPPETCA_EXPIRY_DAYS")
+		viper.BindEnv("puppetca.autosign.enabled", "PUPPETCA_AUTOSIGN_ENABLED")
+		viper.BindEnv("puppetca.autosign.interval", "PUPPETCA_AUTOSIGN_INTERVAL")
 		viper.BindEnv("ui_default_refresh_interval_in_seconds", "UI_DEFAULT_REFRESH_INTERVAL_IN_SECONDS")
+		viper.BindEnv("query_history.backend", "QUERY_HISTORY_BACKEND")
+		viper.BindEnv("query_history.path", "QUERY_HISTORY_PATH")
+		viper.BindEnv("query_history.max_entries", "QUERY_HISTORY_MAX_ENTRIES")
+		viper.BindEnv("query_history.max_pinned", "QUERY_HISTORY_MAX_PINNED")
+		viper.BindEnv("cache.enabled", "CACHE_ENABLED")
+		viper.BindEnv("cache.max_entries", "CACHE_MAX_ENTRIES")
+		viper.BindEnv("query_limits.timeout", "QUERY_LIMITS_TIMEOUT")
+		viper.BindEnv("query_limits.export_timeout", "QUERY_LIMITS_EXPORT_TIMEOUT")
+		viper.BindEnv("query_limits.default_limit", "QUERY_LIMITS_DEFAULT_LIMIT")
+		viper.BindEnv("query_limits.max_limit", "QUERY_LIMITS_MAX_LIMIT")
+		viper.BindEnv("query_limits.allow_unlimited", "QUERY_LIMITS_ALLOW_UNLIMITED")
+		viper.BindEnv("query_limits.max_concurrent", "QUERY_LIMITS_MAX_CONCURRENT")
+		viper.BindEnv("audit.backend", "AUDIT_BACKEND")
+		viper.BindEnv("audit.path", "AUDIT_PATH")
+		viper.BindEnv("audit.max_entries", "AUDIT_MAX_ENTRIES")
+		viper.BindEnv("audit.jsonl_file", "AUDIT_JSONL_FILE")
+		viper.BindEnv("audit.syslog.enabled", "AUDIT_SYSLOG_ENABLED")
+		viper.BindEnv("audit.syslog.network", "AUDIT_SYSLOG_NETWORK")
+		viper.BindEnv("audit.syslog.address", "AUDIT_SYSLOG_ADDRESS")
+		viper.BindEnv("audit.syslog.tag", "AUDIT_SYSLOG_TAG")
+		viper.BindEnv("events.enabled", "EVENTS_ENABLED")
+		viper.BindEnv("events.interval", "EVENTS_INTERVAL")
+		viper.BindEnv("alerting.enabled", "ALERTING_ENABLED")
+		viper.BindEnv("alerting.interval", "ALERTING_INTERVAL")
+		viper.BindEnv("alerting.backend", "ALERTING_BACKEND")
+		viper.BindEnv("alerting.path", "ALERTING_PATH")
+		viper.BindEnv("metrics.enabled", "METRICS_ENABLED")
+		viper.BindEnv("metrics.public", "METRICS_PUBLIC")
+		viper.BindEnv("metrics.certificate_expiries", "METRICS_CERTIFICATE_EXPIRIES")
+		viper.BindEnv("auth.session_secret", "AUTH_SESSION_SECRET")
+		viper.BindEnv("auth.session_lifetime", "AUTH_SESSION_LIFETIME")
+		viper.BindEnv("auth.cookie_secure", "AUTH_COOKIE_SECURE")
+		viper.BindEnv("auth.static.htpasswd_file", "AUTH_STATIC_HTPASSWD_FILE")
+		viper.BindEnv("auth.oidc.issuer", "AUTH_OIDC_ISSUER")
+		viper.BindEnv("auth.oidc.client_id", "AUTH_OIDC_CLIENT_ID")
+		viper.BindEnv("auth.oidc.client_secret", "AUTH_OIDC_CLIENT_SECRET")
+		viper.BindEnv("auth.oidc.redirect_url", "AUTH_OIDC_REDIRECT_URL")
+		viper.BindEnv("auth.oidc.username_claim", "AUTH_OIDC_USERNAME_CLAIM")
+		viper.BindEnv("auth.oidc.groups_claim", "AUTH_OIDC_GROUPS_CLAIM")
+		viper.BindEnv("auth.proxy.user_header", "AUTH_PROXY_USER_HEADER")
+		viper.BindEnv("auth.proxy.groups_header", "AUTH_PROXY_GROUPS_HEADER")
 		viper.BindEnv("log_level", "LOG_LEVEL")
 		viper.BindEnv("log_level", "LOG_FORMAT")
 
@@ -140,6 +391,12 @@ func GetConfig() (*Config, error) {
 		var cfg Config
 		cachedErr = viper.Unmarshal(&cfg)
 		cfg.TrustedProxies = viper.GetStringSlice("trusted_proxies")
+
+		// the metrics contain certnames, with authentication they are only public on request
+		if !viper.IsSet("metrics.public") {
+			cfg.Metrics.Public = !cfg.Auth.Enabled()
+		}
+
 		cachedConfig = &cfg
 	})
 
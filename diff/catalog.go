@@ -0,0 +1,119 @@
+package diff
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+type resourceSummary struct {
+	File     *string `json:"file"`
+	Exported bool    `json:"exported"`
+}
+
+// Catalogs compares two catalogs, e.g. of a canary and a production node. Resources
+// are matched by type and title, their parameters are only compared when a resource
+// is in both catalogs. File paths are shortened with stripPathPrefix (may be nil), so
+// the same manifest in different environments is no change.
+func Catalogs(from *model.Catalog, to *model.Catalog, stripPathPrefix *regexp.Regexp) model.CatalogDiff {
+	changes := []model.Change{}
+
+	changes = append(changes, compareValue(model.DiffSectionCatalog, "environment", from.Environment, to.Environment)...)
+	changes = append(changes, compareValue(model.DiffSectionCatalog, "code_id", from.CodeId, to.CodeId)...)
+
+	fromResources := catalogResources(from, stripPathPrefix)
+	toResources := catalogResources(to, stripPathPrefix)
+
+	for _, change := range compareMaps(model.DiffSectionResource, fromResources, toResources) {
+		if change.Type == model.ChangeChanged {
+			// the parameters are compared below, only the location is reported here
+			old := change.Old.(model.CatalogResource)
+			new := change.New.(model.CatalogResource)
+			changes = append(changes, compareValue(model.DiffSectionResource, change.Key,
+				resourceSummary{File: old.File, Exported: old.Exported},
+				resourceSummary{File: new.File, Exported: new.Exported})...)
+			continue
+		}
+		changes = append(changes, change)
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(fromResources)) {
+		fromResource := fromResources[key]
+		toResource, exists := toResources[key]
+		if !exists {
+			continue
+		}
+
+		for _, change := range compareMaps(model.DiffSectionParameter, fromResource.Parameters, toResource.Parameters) {
+			change.Key = fmt.Sprintf("%s/%s", key, change.Key)
+			changes = append(changes, change)
+		}
+	}
+
+	changes = append(changes, compareMaps(model.DiffSectionEdge, catalogEdges(from), catalogEdges(to))...)
+
+	return model.CatalogDiff{
+		From:    catalogSummary(from),
+		To:      catalogSummary(to),
+		Changes: changes,
+	}
+}
+
+func catalogSummary(catalog *model.Catalog) model.Catalog {
+	result := *catalog
+	result.Resources = nil
+	result.Edges = nil
+	return result
+}
+
+// StripPath shortens the path like the ui does with the strip_path_prefix
+func StripPath(path string, stripPathPrefix *regexp.Regexp) string {
+	if stripPathPrefix == nil {
+		return path
+	}
+
+	loc := stripPathPrefix.FindStringIndex(path)
+	if loc == nil {
+		return path
+	}
+
+	return path[:loc[0]] + "…" + path[loc[1]:]
+}
+
+func catalogResources(catalog *model.Catalog, stripPathPrefix *regexp.Regexp) map[string]model.CatalogResource {
+	result := map[string]model.CatalogResource{}
+	if catalog.Resources == nil {
+		return result
+	}
+
+	for _, resource := range catalog.Resources.Data {
+		if resource.File != nil {
+			file := StripPath(*resource.File, stripPathPrefix)
+			resource.File = &file
+		}
+		if resource.Parameters == nil {
+			resource.Parameters = map[string]any{}
+		}
+
+		result[fmt.Sprintf("%s[%s]", resource.Type, resource.Title)] = resource
+	}
+
+	return result
+}
+
+func catalogEdges(catalog *model.Catalog) map[string]model.CatalogEdge {
+	result := map[string]model.CatalogEdge{}
+	if catalog.Edges == nil {
+		return result
+	}
+
+	for _, edge := range catalog.Edges.Data {
+		key := fmt.Sprintf("%s[%s] %s %s[%s]", edge.SourceType, edge.SourceTitle, edge.Relationship, edge.TargetType, edge.TargetTitle)
+		result[key] = edge
+	}
+
+	return result
+}
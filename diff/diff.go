@@ -0,0 +1,47 @@
+package diff
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+// compareMaps returns the added, removed and changed entries of two maps sorted by key
+func compareMaps[V any](section string, old map[string]V, new map[string]V) []model.Change {
+	changes := []model.Change{}
+
+	keys := slices.Collect(maps.Keys(old))
+	for key := range new {
+		if _, exists := old[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		oldValue, inOld := old[key]
+		newValue, inNew := new[key]
+
+		switch {
+		case !inOld:
+			changes = append(changes, model.Change{Type: model.ChangeAdded, Section: section, Key: key, New: newValue})
+		case !inNew:
+			changes = append(changes, model.Change{Type: model.ChangeRemoved, Section: section, Key: key, Old: oldValue})
+		case !reflect.DeepEqual(oldValue, newValue):
+			changes = append(changes, model.Change{Type: model.ChangeChanged, Section: section, Key: key, Old: oldValue, New: newValue})
+		}
+	}
+
+	return changes
+}
+
+// compareValue returns a change if the values differ
+func compareValue(section string, key string, old any, new any) []model.Change {
+	if reflect.DeepEqual(old, new) {
+		return nil
+	}
+
+	return []model.Change{{Type: model.ChangeChanged, Section: section, Key: key, Old: old, New: new}}
+}
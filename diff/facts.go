@@ -0,0 +1,54 @@
+package diff
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+// Facts compares the facts of the given nodes leaf by leaf and returns the leaves
+// whose value differs or which are missing on some nodes, sorted by path
+func Facts(certnames []string, facts []model.Fact) model.FactDiff {
+	leaves := map[string]map[string]any{}
+	for _, fact := range facts {
+		for path, value := range model.FactLeaves(fact.Name, fact.Value) {
+			if _, exists := leaves[path]; !exists {
+				leaves[path] = map[string]any{}
+			}
+			leaves[path][fact.Certname] = value
+		}
+	}
+
+	differences := []model.FactDifference{}
+	for _, path := range slices.Sorted(maps.Keys(leaves)) {
+		values := leaves[path]
+		if !allEqual(certnames, values) {
+			differences = append(differences, model.FactDifference{
+				Path:   path,
+				Values: values,
+			})
+		}
+	}
+
+	return model.FactDiff{
+		Certnames:   certnames,
+		Differences: differences,
+	}
+}
+
+func allEqual(certnames []string, values map[string]any) bool {
+	if len(values) != len(certnames) {
+		return false
+	}
+
+	first := values[certnames[0]]
+	for _, certname := range certnames[1:] {
+		if !reflect.DeepEqual(first, values[certname]) {
+			return false
+		}
+	}
+
+	return true
+}
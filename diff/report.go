@@ -0,0 +1,170 @@
+package diff
+
+import (
+	"fmt"
+
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+// resource states derived from the events of a resource, a resource without events
+// was unchanged in that run
+const (
+	resourceUnchanged = "unchanged"
+	resourceSkipped   = "skipped"
+	resourceNoop      = "noop"
+	resourceChanged   = "changed"
+	resourceFailed    = "failed"
+)
+
+var eventResourceStatus = map[string]string{
+	"skipped": resourceSkipped,
+	"noop":    resourceNoop,
+	"success": resourceChanged,
+	"failure": resourceFailed,
+}
+
+// resourceStatusRank orders the states, a resource with a failed and a successful
+// event is failed
+var resourceStatusRank = map[string]int{
+	resourceUnchanged: 0,
+	resourceSkipped:   1,
+	resourceNoop:      2,
+	resourceChanged:   3,
+	resourceFailed:    4,
+}
+
+type eventSummary struct {
+	Status   string  `json:"status"`
+	OldValue any     `json:"old_value"`
+	NewValue any     `json:"new_value"`
+	Message  *string `json:"message"`
+}
+
+// Reports compares two reports, usually two runs of the same node. The changes are
+// grouped by section: report fields, resource states, events, logs and timing metrics.
+func Reports(from *model.Report, to *model.Report) model.ReportDiff {
+	changes := []model.Change{}
+
+	changes = append(changes, compareValue(model.DiffSectionReport, "status", from.Status, to.Status)...)
+	changes = append(changes, compareValue(model.DiffSectionReport, "environment", from.Environment, to.Environment)...)
+	changes = append(changes, compareValue(model.DiffSectionReport, "configuration_version", from.ConfigurationVersion, to.ConfigurationVersion)...)
+	changes = append(changes, compareValue(model.DiffSectionReport, "catalog_uuid", from.CatalogUuid, to.CatalogUuid)...)
+	changes = append(changes, compareValue(model.DiffSectionReport, "code_id", from.CodeId, to.CodeId)...)
+	changes = append(changes, compareValue(model.DiffSectionReport, "puppet_version", from.PuppetVersion, to.PuppetVersion)...)
+	changes = append(changes, compareValue(model.DiffSectionReport, "cached_catalog_status", from.CachedCatalogStatus, to.CachedCatalogStatus)...)
+	changes = append(changes, compareValue(model.DiffSectionReport, "noop", from.Noop, to.Noop)...)
+	changes = append(changes, compareValue(model.DiffSectionReport, "corrective_change", from.CorrectiveChange, to.CorrectiveChange)...)
+
+	changes = append(changes, compareResourceStatuses(resourceStatuses(from), resourceStatuses(to))...)
+	changes = append(changes, compareMaps(model.DiffSectionEvent, events(from), events(to))...)
+	changes = append(changes, compareMaps(model.DiffSectionLog, logs(from), logs(to))...)
+	changes = append(changes, compareMaps(model.DiffSectionMetric, timingMetrics(from), timingMetrics(to))...)
+
+	return model.ReportDiff{
+		From:    summary(from),
+		To:      summary(to),
+		Changes: changes,
+	}
+}
+
+func summary(report *model.Report) model.Report {
+	result := *report
+	result.Metrics = nil
+	result.Logs = nil
+	result.Events = nil
+	return result
+}
+
+func resourceKey(event model.ResourceEvent) string {
+	return fmt.Sprintf("%s[%s]", event.ResourceType, event.ResourceTitle)
+}
+
+func resourceStatuses(report *model.Report) map[string]string {
+	statuses := map[string]string{}
+	if report.Events == nil {
+		return statuses
+	}
+
+	for _, event := range report.Events.Data {
+		key := resourceKey(event)
+		status, known := eventResourceStatus[event.Status]
+		if !known {
+			continue
+		}
+
+		if resourceStatusRank[status] > resourceStatusRank[statuses[key]] {
+			statuses[key] = status
+		}
+	}
+
+	return statuses
+}
+
+// compareResourceStatuses reports every resource whose state changed, resources
+// missing in one report were unchanged in that run
+func compareResourceStatuses(old map[string]string, new map[string]string) []model.Change {
+	fill := func(statuses map[string]string, other map[string]string) {
+		for key := range other {
+			if _, exists := statuses[key]; !exists {
+				statuses[key] = resourceUnchanged
+			}
+		}
+	}
+	fill(old, new)
+	fill(new, old)
+
+	return compareMaps(model.DiffSectionResource, old, new)
+}
+
+func events(report *model.Report) map[string]eventSummary {
+	result := map[string]eventSummary{}
+	if report.Events == nil {
+		return result
+	}
+
+	for _, event := range report.Events.Data {
+		key := resourceKey(event)
+		if event.Property != nil {
+			key = fmt.Sprintf("%s/%s", key, *event.Property)
+		}
+
+		result[key] = eventSummary{
+			Status:   event.Status,
+			OldValue: event.OldValue,
+			NewValue: event.NewValue,
+			Message:  event.Message,
+		}
+	}
+
+	return result
+}
+
+// logs are compared by source and message with the level as value, timing information
+// in the messages (e.g. "Applied catalog in 1.23 seconds") shows up as removed and added
+func logs(report *model.Report) map[string]string {
+	result := map[string]string{}
+	if report.Logs == nil {
+		return result
+	}
+
+	for _, log := range report.Logs.Data {
+		result[fmt.Sprintf("%s: %s", log.Source, log.Message)] = log.Level
+	}
+
+	return result
+}
+
+func timingMetrics(report *model.Report) map[string]float64 {
+	result := map[string]float64{}
+	if report.Metrics == nil {
+		return result
+	}
+
+	for _, metric := range report.Metrics.Data {
+		if metric.Category == "time" {
+			result[fmt.Sprintf("%s.%s", metric.Category, metric.Name)] = metric.Value
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,252 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/sebastianrakel/openvoxview/model"
+	"github.com/sebastianrakel/openvoxview/puppetca"
+	"github.com/sebastianrakel/openvoxview/puppetdb"
+)
+
+// subscriptionBuffer is the number of events a subscriber can fall behind before events
+// are dropped for it
+const subscriptionBuffer = 100
+
+// Watcher polls PuppetDB and Puppet CA on a schedule and sends the changes to all
+// subscribers, so every client gets them from one poll
+type Watcher struct {
+	pdbClient       *puppetdb.Client
+	caClient        *puppetca.Client
+	interval        time.Duration
+	unreportedHours uint64
+
+	mu            sync.Mutex
+	subscriptions map[*Subscription]struct{}
+	lastId        uint64
+
+	// wake starts a poll when the first client subscribes
+	wake chan struct{}
+
+	// only used by the polling goroutine
+	nodes         map[string]model.Node
+	nodesPolledAt time.Time
+	requests      map[string]model.CertificateStatus
+}
+
+// Subscription receives the events matching its query until it is unsubscribed
+type Subscription struct {
+	query  model.FleetEventQuery
+	events chan model.FleetEvent
+}
+
+func (s *Subscription) Events() <-chan model.FleetEvent {
+	return s.events
+}
+
+// NewWatcher creates the watcher, without caClient no certificate requests are watched
+func NewWatcher(pdbClient *puppetdb.Client, caClient *puppetca.Client, interval time.Duration, unreportedHours uint64) (*Watcher, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("events interval must be positive, got %s", interval)
+	}
+
+	return &Watcher{
+		pdbClient:       pdbClient.WithoutCache(),
+		caClient:        caClient,
+		interval:        interval,
+		unreportedHours: unreportedHours,
+		subscriptions:   map[*Subscription]struct{}{},
+		wake:            make(chan struct{}, 1),
+	}, nil
+}
+
+func (w *Watcher) Subscribe(query model.FleetEventQuery) *Subscription {
+	subscription := &Subscription{
+		query:  query,
+		events: make(chan model.FleetEvent, subscriptionBuffer),
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.subscriptions[subscription] = struct{}{}
+	if len(w.subscriptions) == 1 {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+	return subscription
+}
+
+func (w *Watcher) Unsubscribe(subscription *Subscription) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, exists := w.subscriptions[subscription]; exists {
+		delete(w.subscriptions, subscription)
+		close(subscription.events)
+	}
+}
+
+// Run polls until the context is done. Without subscribers nothing is polled and the
+// state is dropped, the first poll after a client subscribes only records the state again.
+func (w *Watcher) Run(ctx context.Context) {
+	slog.Info("event watcher started", "interval", w.interval)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if w.hasSubscribers() {
+			w.poll(time.Now())
+		} else {
+			w.nodes = nil
+			w.requests = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+func (w *Watcher) hasSubscribers() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.subscriptions) > 0
+}
+
+// poll compares the current state with the previous poll, the first successful poll
+// only records the state
+func (w *Watcher) poll(now time.Time) {
+	nodes, err := w.pdbClient.GetNodes(&puppetdb.PdbQuery{})
+	if err != nil {
+		slog.Error("event watcher: error getting nodes", "error", err)
+	} else {
+		current := make(map[string]model.Node, len(nodes))
+		for _, node := range nodes {
+			current[node.Name] = node
+		}
+
+		if w.nodes != nil {
+			w.publish(diffNodes(w.nodes, w.nodesPolledAt, current, now, w.unreportedHours))
+		}
+		w.nodes = current
+		w.nodesPolledAt = now
+	}
+
+	if w.caClient == nil {
+		return
+	}
+
+	state := model.CertificateRequested
+	certs, err := w.caClient.GetCertificates(&state)
+	if err != nil {
+		slog.Error("event watcher: error getting certificate requests", "error", err)
+		return
+	}
+
+	current := make(map[string]model.CertificateStatus, len(certs))
+	for _, cert := range certs {
+		current[cert.Name] = cert
+	}
+
+	if w.requests != nil {
+		w.publish(diffRequests(w.requests, current))
+	}
+	w.requests = current
+}
+
+// publish numbers the events and sends them to the matching subscribers, a subscriber
+// that is not keeping up misses the event instead of blocking the others
+func (w *Watcher) publish(events []model.FleetEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	for _, event := range events {
+		w.lastId++
+		event.Id = w.lastId
+		event.Timestamp = now
+
+		for subscription := range w.subscriptions {
+			if !subscription.query.Matches(event) {
+				continue
+			}
+
+			select {
+			case subscription.events <- event:
+			default:
+				slog.Warn("event watcher: subscriber is not keeping up, dropping event", "id", event.Id, "type", event.Type)
+			}
+		}
+	}
+}
+
+// diffNodes returns the new reports, status changes and nodes that became unreported
+// between the poll at previousTime and now
+func diffNodes(previous map[string]model.Node, previousTime time.Time, current map[string]model.Node, now time.Time, unreportedHours uint64) []model.FleetEvent {
+	var events []model.FleetEvent
+
+	for certname, node := range current {
+		event := model.FleetEvent{
+			Certname:        certname,
+			Environment:     node.Environment(),
+			Status:          node.LatestReportStatus,
+			ReportHash:      node.LatestReportHash,
+			ReportTimestamp: node.ReportTimestamp,
+		}
+
+		before, known := previous[certname]
+
+		if node.LatestReportHash != "" && node.LatestReportHash != before.LatestReportHash {
+			event.Type = model.FleetEventReport
+			events = append(events, event)
+		}
+
+		if known && before.LatestReportStatus != "" && node.LatestReportStatus != before.LatestReportStatus {
+			event.Type = model.FleetEventStatus
+			event.PreviousStatus = before.LatestReportStatus
+			events = append(events, event)
+			event.PreviousStatus = ""
+		}
+
+		if known && !before.IsUnreported(previousTime, unreportedHours) && node.IsUnreported(now, unreportedHours) {
+			event.Type = model.FleetEventUnreported
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// diffRequests returns the certificate requests that were not pending before
+func diffRequests(previous map[string]model.CertificateStatus, current map[string]model.CertificateStatus) []model.FleetEvent {
+	var events []model.FleetEvent
+
+	for name, cert := range current {
+		if before, known := previous[name]; known && before.Fingerprint == cert.Fingerprint {
+			continue
+		}
+
+		events = append(events, model.FleetEvent{
+			Type:        model.FleetEventCertificateRequest,
+			Certname:    name,
+			Fingerprint: cert.Fingerprint,
+		})
+	}
+
+	return events
+}
@@ -0,0 +1,198 @@
+package events
+
+import (
+	"cmp"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/model"
+	"github.com/sebastianrakel/openvoxview/puppetdb"
+)
+
+var testNow = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+func testNode(certname string, status string, hash string, reportedBefore time.Duration) model.Node {
+	environment := "production"
+	node := model.Node{
+		Name:               certname,
+		CatalogEnvironment: &environment,
+		LatestReportStatus: status,
+		LatestReportHash:   hash,
+	}
+	if reportedBefore >= 0 {
+		node.ReportTimestamp = &model.PuppetTime{Time: testNow.Add(-reportedBefore)}
+	}
+	return node
+}
+
+func nodeMap(nodes ...model.Node) map[string]model.Node {
+	mapped := map[string]model.Node{}
+	for _, node := range nodes {
+		mapped[node.Name] = node
+	}
+	return mapped
+}
+
+// eventKeys returns the events as type:certname with the previous status or the
+// fingerprint, sorted for comparison
+func eventKeys(events []model.FleetEvent) []string {
+	keys := []string{}
+	for _, event := range events {
+		key := event.Type + ":" + event.Certname
+		if event.PreviousStatus != "" {
+			key += ":" + event.PreviousStatus
+		}
+		if event.Fingerprint != "" {
+			key += ":" + event.Fingerprint
+		}
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, cmp.Compare)
+	return keys
+}
+
+func TestDiffNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous []model.Node
+		current  []model.Node
+		want     []string
+	}{
+		{"unchanged", []model.Node{testNode("web1", "unchanged", "a", time.Hour)}, []model.Node{testNode("web1", "unchanged", "a", time.Hour)}, []string{}},
+		{"new report", []model.Node{testNode("web1", "unchanged", "a", time.Hour)}, []model.Node{testNode("web1", "unchanged", "b", 0)}, []string{"report:web1"}},
+		{"status change", []model.Node{testNode("web1", "failed", "a", time.Hour)}, []model.Node{testNode("web1", "changed", "b", 0)}, []string{"report:web1", "status:web1:failed"}},
+		{"first status", []model.Node{testNode("web1", "", "", -1)}, []model.Node{testNode("web1", "changed", "b", 0)}, []string{"report:web1"}},
+		{"new node with report", []model.Node{}, []model.Node{testNode("web1", "changed", "b", 0)}, []string{"report:web1"}},
+		{"new node without report", []model.Node{}, []model.Node{testNode("web1", "", "", -1)}, []string{}},
+		{"became unreported", []model.Node{testNode("web1", "unchanged", "a", 2*time.Hour+30*time.Second)}, []model.Node{testNode("web1", "unchanged", "a", 2*time.Hour+30*time.Second)}, []string{"unreported:web1"}},
+		{"still unreported", []model.Node{testNode("web1", "unchanged", "a", 3*time.Hour)}, []model.Node{testNode("web1", "unchanged", "a", 3*time.Hour)}, []string{}},
+		{"removed node", []model.Node{testNode("web1", "unchanged", "a", time.Hour)}, []model.Node{}, []string{}},
+		{
+			"several nodes",
+			[]model.Node{testNode("web1", "unchanged", "a", time.Hour), testNode("web2", "changed", "c", time.Hour)},
+			[]model.Node{testNode("web1", "failed", "b", 0), testNode("web2", "changed", "c", time.Hour), testNode("web3", "unchanged", "d", 0)},
+			[]string{"report:web1", "report:web3", "status:web1:unchanged"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events := diffNodes(nodeMap(test.previous...), testNow.Add(-time.Minute), nodeMap(test.current...), testNow, 2)
+
+			if keys := eventKeys(events); !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("events = %v, want %v", keys, test.want)
+			}
+			for _, event := range events {
+				if event.Environment != "production" {
+					t.Errorf("environment of %s = %q, want production", event.Certname, event.Environment)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffRequests(t *testing.T) {
+	request := func(name string, fingerprint string) model.CertificateStatus {
+		return model.CertificateStatus{Name: name, State: model.CertificateRequested, Fingerprint: fingerprint}
+	}
+	requests := func(certs ...model.CertificateStatus) map[string]model.CertificateStatus {
+		mapped := map[string]model.CertificateStatus{}
+		for _, cert := range certs {
+			mapped[cert.Name] = cert
+		}
+		return mapped
+	}
+
+	tests := []struct {
+		name     string
+		previous map[string]model.CertificateStatus
+		current  map[string]model.CertificateStatus
+		want     []string
+	}{
+		{"none", requests(), requests(), []string{}},
+		{"new request", requests(), requests(request("web1", "AA")), []string{"certificate_request:web1:AA"}},
+		{"still pending", requests(request("web1", "AA")), requests(request("web1", "AA")), []string{}},
+		{"new key", requests(request("web1", "AA")), requests(request("web1", "BB")), []string{"certificate_request:web1:BB"}},
+		{"signed or rejected", requests(request("web1", "AA")), requests(), []string{}},
+		{"one of several", requests(request("web1", "AA")), requests(request("web1", "AA"), request("web2", "CC")), []string{"certificate_request:web2:CC"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if keys := eventKeys(diffRequests(test.previous, test.current)); !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("events = %v, want %v", keys, test.want)
+			}
+		})
+	}
+}
+
+func TestPollFirstRecordsState(t *testing.T) {
+	var mu sync.Mutex
+	nodes := []model.Node{testNode("web1", "unchanged", "a", time.Hour)}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pdb/query/v4/nodes" {
+			t.Errorf("unexpected request of %s", r.URL.Path)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewEncoder(w).Encode(nodes)
+	}))
+	defer server.Close()
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.PuppetDB.Host = host
+	cfg.PuppetDB.Port, _ = strconv.ParseUint(port, 10, 64)
+
+	watcher, err := NewWatcher(puppetdb.NewClient(cfg), nil, time.Minute, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription := watcher.Subscribe(model.FleetEventQuery{})
+
+	received := func() []string {
+		events := []model.FleetEvent{}
+		for {
+			select {
+			case event := <-subscription.Events():
+				events = append(events, event)
+			default:
+				return eventKeys(events)
+			}
+		}
+	}
+
+	watcher.poll(testNow)
+	if events := received(); len(events) != 0 {
+		t.Errorf("events of the first poll = %v, want none", events)
+	}
+
+	mu.Lock()
+	nodes = []model.Node{testNode("web1", "failed", "b", 0)}
+	mu.Unlock()
+
+	watcher.poll(testNow.Add(time.Minute))
+	if events, want := received(), []string{"report:web1", "status:web1:unchanged"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events of the second poll = %v, want %v", events, want)
+	}
+
+	watcher.poll(testNow.Add(2 * time.Minute))
+	if events := received(); len(events) != 0 {
+		t.Errorf("events of an unchanged poll = %v, want none", events)
+	}
+}
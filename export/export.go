@@ -0,0 +1,304 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	FORMAT_CSV    = "csv"
+	FORMAT_XLSX   = "xlsx"
+	FORMAT_NDJSON = "ndjson"
+)
+
+var contentTypes = map[string]string{
+	FORMAT_CSV:    "text/csv; charset=utf-8",
+	FORMAT_XLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	FORMAT_NDJSON: "application/x-ndjson",
+}
+
+// Negotiate returns the export format of a request, the format parameter wins over the
+// Accept header. An empty format means the normal json response.
+func Negotiate(format string, accept string) (string, error) {
+	if format != "" {
+		format = strings.ToLower(format)
+		if format == "json" {
+			return "", nil
+		}
+		if _, exists := contentTypes[format]; !exists {
+			return "", fmt.Errorf("unknown export format %q (json, csv, xlsx, ndjson)", format)
+		}
+		return format, nil
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		for format, contentType := range contentTypes {
+			if strings.HasPrefix(contentType, mediaType) {
+				return format, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Writer writes rows with the columns given on creation. CSV and NDJSON are written
+// to the output while rows are added, XLSX needs the complete file and is written on
+// Close.
+type Writer interface {
+	Write(row []any) error
+	Close() error
+}
+
+func NewWriter(format string, out io.Writer, columns []string) (Writer, error) {
+	switch format {
+	case FORMAT_CSV:
+		return newCsvWriter(out, columns)
+	case FORMAT_XLSX:
+		return newXlsxWriter(out, columns)
+	case FORMAT_NDJSON:
+		return &ndjsonWriter{out: out, columns: columns}, nil
+	}
+
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// ObjectColumns returns the keys of json objects in the order of their first
+// appearance, used as columns for query results
+func ObjectColumns(objects []json.RawMessage) []string {
+	columns := []string{}
+	seen := map[string]bool{}
+
+	for _, object := range objects {
+		decoder := json.NewDecoder(bytes.NewReader(object))
+		if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+			continue
+		}
+
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				break
+			}
+
+			key, _ := token.(string)
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+
+			var skip json.RawMessage
+			if err := decoder.Decode(&skip); err != nil {
+				break
+			}
+		}
+	}
+
+	return columns
+}
+
+// cellText formats a value for csv and xlsx, structured values are written as json.
+// Text starting like a formula is prefixed with a quote, so spreadsheets do not evaluate
+// values like =HYPERLINK(...) from facts or reports.
+func cellText(value any) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if typed != "" && strings.ContainsRune("=+-@\t\r", rune(typed[0])) {
+			return "'" + typed
+		}
+		return typed
+	case json.RawMessage:
+		var decoded any
+		if err := json.Unmarshal(typed, &decoded); err == nil {
+			return cellText(decoded)
+		}
+		return string(typed)
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(typed), 'f', -1, 32)
+	case json.Number:
+		return typed.String()
+	case map[string]any, []any:
+		data, _ := json.Marshal(typed)
+		return string(data)
+	default:
+		return fmt.Sprint(typed)
+	}
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+	record []string
+}
+
+func newCsvWriter(out io.Writer, columns []string) (*csvWriter, error) {
+	w := &csvWriter{
+		writer: csv.NewWriter(out),
+		record: make([]string, len(columns)),
+	}
+
+	return w, w.writer.Write(columns)
+}
+
+func (w *csvWriter) Write(row []any) error {
+	for i := range w.record {
+		w.record[i] = ""
+		if i < len(row) {
+			w.record[i] = cellText(row[i])
+		}
+	}
+
+	return w.writer.Write(w.record)
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type ndjsonWriter struct {
+	out     io.Writer
+	columns []string
+}
+
+// Write writes the row as json object with the keys in column order
+func (w *ndjsonWriter) Write(row []any) error {
+	var line strings.Builder
+	line.WriteByte('{')
+
+	for i, column := range w.columns {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+
+		key, err := json.Marshal(column)
+		if err != nil {
+			return err
+		}
+
+		var value any
+		if i < len(row) {
+			value = row[i]
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		line.Write(key)
+		line.WriteByte(':')
+		line.Write(data)
+	}
+
+	line.WriteString("}\n")
+	_, err := io.WriteString(w.out, line.String())
+	return err
+}
+
+func (w *ndjsonWriter) Close() error {
+	return nil
+}
+
+type xlsxWriter struct {
+	out    io.Writer
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	rowNum int
+}
+
+func newXlsxWriter(out io.Writer, columns []string) (*xlsxWriter, error) {
+	file := excelize.NewFile()
+
+	stream, err := file.NewStreamWriter("Sheet1")
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w := &xlsxWriter{
+		out:    out,
+		file:   file,
+		stream: stream,
+	}
+
+	header := make([]any, len(columns))
+	for i, column := range columns {
+		header[i] = column
+	}
+
+	return w, w.writeRow(header)
+}
+
+func (w *xlsxWriter) writeRow(values []any) error {
+	w.rowNum++
+
+	cell, err := excelize.CoordinatesToCellName(1, w.rowNum)
+	if err != nil {
+		return err
+	}
+
+	return w.stream.SetRow(cell, values)
+}
+
+// Write keeps numbers and booleans as typed cells, everything else is text
+func (w *xlsxWriter) Write(row []any) error {
+	values := make([]any, len(row))
+	for i, value := range row {
+		switch typed := value.(type) {
+		case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, bool:
+			values[i] = typed
+		case json.Number:
+			if number, err := typed.Float64(); err == nil {
+				values[i] = number
+				continue
+			}
+			values[i] = cellText(typed)
+		case json.RawMessage:
+			var decoded any
+			if err := json.Unmarshal(typed, &decoded); err == nil {
+				switch decoded.(type) {
+				case float64, bool:
+					values[i] = decoded
+					continue
+				}
+			}
+			values[i] = cellText(typed)
+		default:
+			values[i] = cellText(typed)
+		}
+	}
+
+	return w.writeRow(values)
+}
+
+func (w *xlsxWriter) Close() error {
+	defer w.file.Close()
+
+	if err := w.stream.Flush(); err != nil {
+		return err
+	}
+
+	_, err := w.file.WriteTo(w.out)
+	return err
+}
@@ -0,0 +1,31 @@
+package export
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCellText(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{nil, ""},
+		{"web1", "web1"},
+		{"=HYPERLINK(1)", "'=HYPERLINK(1)"},
+		{float64(1000000), "1000000"},
+		{1.5, "1.5"},
+		{float64(1e21), "1000000000000000000000"},
+		{json.Number("12345678901234567890"), "12345678901234567890"},
+		{json.RawMessage(`1e6`), "1000000"},
+		{json.RawMessage(`{"a":1}`), `{"a":1}`},
+		{[]any{"a", 1.0}, `["a",1]`},
+		{true, "true"},
+	}
+
+	for _, test := range tests {
+		if got := cellText(test.value); got != test.want {
+			t.Errorf("cellText(%#v) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianrakel/openvoxview/audit"
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+type AuditHandler struct {
+	auditLogger *audit.Logger
+}
+
+func NewAuditHandler(auditLogger *audit.Logger) *AuditHandler {
+	return &AuditHandler{
+		auditLogger: auditLogger,
+	}
+}
+
+func (h *AuditHandler) QueryAuditEvents(c *gin.Context) {
+	var query model.AuditEventQuery
+	if err := c.BindQuery(&query); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	events, total, err := h.auditLogger.Query(query)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	response := model.AuditEventResponse{
+		Events: events,
+		Total:  total,
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(response))
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianrakel/openvoxview/auth"
+)
+
+type AuthHandler struct {
+	authenticator *auth.Authenticator
+}
+
+func NewAuthHandler(authenticator *auth.Authenticator) *AuthHandler {
+	return &AuthHandler{
+		authenticator: authenticator,
+	}
+}
+
+type LoginRequest struct {
+	Username string `binding:"required"`
+	Password string `binding:"required"`
+}
+
+type MeResponse struct {
+	AuthEnabled   bool
+	Authenticated bool
+	Providers     []string
+	User          *auth.User
+	Permissions   []auth.Permission
+}
+
+// RequireAuth rejects all requests without an authenticated user, the given public
+// paths stay reachable for everyone. Paths ending with a slash match everything below
+// them (e.g. the login endpoints), all others only match exactly.
+func (h *AuthHandler) RequireAuth(publicPaths ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.authenticator.Enabled() || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+
+		if user := h.authenticator.Authenticate(c); user != nil {
+			auth.SetUser(c, user)
+			c.Next()
+			return
+		}
+
+		if isPublicPath(c.Request.URL.Path, publicPaths) {
+			c.Next()
+			return
+		}
+
+		// the ui is sent directly to the login page of the issuer
+		if !strings.HasPrefix(c.Request.URL.Path, "/api/") && h.authenticator.OIDCEnabled() {
+			c.Redirect(http.StatusTemporaryRedirect, "/api/v1/auth/oidc/login")
+			c.Abort()
+			return
+		}
+
+		if h.authenticator.StaticEnabled() {
+			c.Header("WWW-Authenticate", `Basic realm="openvoxview", charset="UTF-8"`)
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, NewErrorResponse(errors.New("authentication required")))
+	}
+}
+
+func isPublicPath(path string, publicPaths []string) bool {
+	for _, public := range publicPaths {
+		if strings.HasSuffix(public, "/") && strings.HasPrefix(path, public) {
+			return true
+		}
+		if path == public {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission rejects requests of users without the given permission
+func (h *AuthHandler) RequirePermission(permission auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := auth.UserFromContext(c)
+		if !h.authenticator.HasPermission(user, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, NewErrorResponse(fmt.Errorf("permission %s required", permission)))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Permissions returns the permissions of the user of the request
+func (h *AuthHandler) Permissions(c *gin.Context) []auth.Permission {
+	return h.authenticator.Permissions(auth.UserFromContext(c))
+}
+
+// userName returns the name of the authenticated user, empty when authentication
+// is disabled. Per user data like the query history is scoped by this name.
+func userName(c *gin.Context) string {
+	if user := auth.UserFromContext(c); user != nil {
+		return user.Name
+	}
+	return ""
+}
+
+// auditUser returns the user recorded in the audit log, the client ip when
+// authentication is disabled
+func auditUser(c *gin.Context) string {
+	if user := userName(c); user != "" {
+		return user
+	}
+	return c.ClientIP()
+}
+
+func (h *AuthHandler) Me(c *gin.Context) {
+	user := auth.UserFromContext(c)
+
+	response := MeResponse{
+		AuthEnabled:   h.authenticator.Enabled(),
+		Authenticated: user != nil,
+		Providers:     h.authenticator.Providers(),
+		User:          user,
+		Permissions:   h.authenticator.Permissions(user),
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(response))
+}
+
+func (h *AuthHandler) Login(c *gin.Context) {
+	var loginRequest LoginRequest
+	if err := c.ShouldBindJSON(&loginRequest); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	user, err := h.authenticator.Login(loginRequest.Username, loginRequest.Password)
+	if err != nil {
+		slog.Info("login failed", "username", loginRequest.Username, "error", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, NewErrorResponse(auth.ErrInvalidCredentials))
+		return
+	}
+
+	if err := h.authenticator.StartSession(c, user); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	slog.Info("user logged in", "username", user.Name, "provider", user.Provider)
+	c.JSON(http.StatusOK, NewSuccessResponse(user))
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	h.authenticator.EndSession(c)
+	c.JSON(http.StatusOK, NewSuccessResponse(nil))
+}
+
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	if !h.authenticator.OIDCEnabled() {
+		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(errors.New("oidc is not configured")))
+		return
+	}
+
+	loginUrl, err := h.authenticator.OIDCLoginURL(c)
+	if err != nil {
+		slog.Error("error starting oidc login", "error", err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, NewErrorResponse(err))
+		return
+	}
+
+	c.Redirect(http.StatusFound, loginUrl)
+}
+
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	user, err := h.authenticator.OIDCCallback(c)
+	if err != nil {
+		slog.Info("oidc login failed", "error", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, NewErrorResponse(err))
+		return
+	}
+
+	if err := h.authenticator.StartSession(c, user); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	slog.Info("user logged in", "username", user.Name, "provider", user.Provider)
+	c.Redirect(http.StatusFound, "/ui/?#/")
+}
@@ -1,12 +1,18 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sebastianrakel/openvoxview/audit"
+	"github.com/sebastianrakel/openvoxview/autosign"
 	"github.com/sebastianrakel/openvoxview/config"
 	"github.com/sebastianrakel/openvoxview/model"
 	"github.com/sebastianrakel/openvoxview/puppetca"
@@ -14,14 +20,20 @@ import (
 )
 
 type CaHandler struct {
-	config   *config.Config
-	caClient *puppetca.Client
+	config         *config.Config
+	caClient       *puppetca.Client
+	pdbClient      *puppetdb.Client
+	auditLogger    *audit.Logger
+	autosignRunner *autosign.Runner
 }
 
-func NewCaHandler(config *config.Config) *CaHandler {
+func NewCaHandler(config *config.Config, caClient *puppetca.Client, pdbClient *puppetdb.Client, auditLogger *audit.Logger, autosignRunner *autosign.Runner) *CaHandler {
 	return &CaHandler{
-		config:   config,
-		caClient: puppetca.NewClient(config),
+		config:         config,
+		caClient:       caClient,
+		pdbClient:      pdbClient,
+		auditLogger:    auditLogger,
+		autosignRunner: autosignRunner,
 	}
 }
 
@@ -33,6 +45,20 @@ func (h *CaHandler) QueryCertificateStatuses(c *gin.Context) {
 		return
 	}
 
+	resultCerts, err := h.queryCertificates(query)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	response := model.CertificateStatusResponse{
+		CertificateStatuses: resultCerts,
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(response))
+}
+
+func (h *CaHandler) queryCertificates(query model.CertificateStatusQuery) ([]model.CertificateStatus, error) {
 	if query.States != nil {
 		states := model.UniqueCertificateStates(*query.States)
 
@@ -50,16 +76,14 @@ func (h *CaHandler) QueryCertificateStatuses(c *gin.Context) {
 		for _, state := range *query.States {
 			certs, err := h.caClient.GetCertificates(&state)
 			if err != nil {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
-				return
+				return nil, err
 			}
 			resultCerts = append(resultCerts, certs...)
 		}
 	} else {
 		certs, err := h.caClient.GetCertificates(nil)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
-			return
+			return nil, err
 		}
 		resultCerts = certs
 	}
@@ -78,22 +102,59 @@ func (h *CaHandler) QueryCertificateStatuses(c *gin.Context) {
 		})
 	}
 
-	response := model.CertificateStatusResponse{
-		CertificateStatuses: resultCerts,
+	return resultCerts, nil
+}
+
+type CertificateExpiryQuery struct {
+	Days int `form:"days" binding:"min=0"`
+}
+
+// ExpiringCertificates lists the signed certificates expiring within the window,
+// grouped by days remaining
+func (h *CaHandler) ExpiringCertificates(c *gin.Context) {
+	var query CertificateExpiryQuery
+	if err := c.BindQuery(&query); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	if query.Days == 0 {
+		query.Days = h.config.PuppetCA.ExpiryDays
+	}
+
+	state := model.CertificateSigned
+	certs, err := h.caClient.GetCertificates(&state)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	expiring := model.ExpiringCertificates(certs, time.Now(), time.Duration(query.Days)*24*time.Hour)
+
+	response := model.CertificateExpiryResponse{
+		WindowDays: query.Days,
+		Total:      len(expiring),
+		Groups:     model.GroupExpiringCertificates(expiring),
 	}
 
 	c.JSON(http.StatusOK, NewSuccessResponse(response))
 }
 
-func (h *CaHandler) SignCertificate(c *gin.Context) {
-	name := c.Param("name")
-
-	slog.Info("ca signing", "certname", name)
+// AutosignDryRun shows which pending certificate requests would be signed by the
+// autosign policies and why
+func (h *CaHandler) AutosignDryRun(c *gin.Context) {
+	decisions, err := h.autosignRunner.DryRun()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
 
-	err := h.caClient.SignCertificate(name)
+	c.JSON(http.StatusOK, NewSuccessResponse(decisions))
+}
 
+func (h *CaHandler) SignCertificate(c *gin.Context) {
+	err := h.signCertificate(auditUser(c), c.Param("name"))
 	if err != nil {
-		slog.Error("error signing certificate", "error", err)
 		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
 		return
 	}
@@ -102,19 +163,17 @@ func (h *CaHandler) SignCertificate(c *gin.Context) {
 }
 
 func (h *CaHandler) RevokeCertificate(c *gin.Context) {
-	name := c.Param("name")
-
-	slog.Info("ca revoking", "certname", name)
-
-	err := h.caClient.RevokeCertificate(name)
-
+	err := h.revokeCertificate(auditUser(c), c.Param("name"))
 	if err != nil {
-		slog.Error("error revoking certificate", "error", err)
 		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
 		return
 	}
 
-	err = h.deactivateNode(name)
+	c.JSON(http.StatusOK, NewSuccessResponse(nil))
+}
+
+func (h *CaHandler) CleanCertificate(c *gin.Context) {
+	err := h.cleanCertificate(auditUser(c), c.Param("name"))
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
 		return
@@ -123,43 +182,234 @@ func (h *CaHandler) RevokeCertificate(c *gin.Context) {
 	c.JSON(http.StatusOK, NewSuccessResponse(nil))
 }
 
-func (h *CaHandler) CleanCertificate(c *gin.Context) {
-	name := c.Param("name")
+func (h *CaHandler) BulkSignCertificates(c *gin.Context) {
+	h.bulk(c, h.signCertificate)
+}
 
-	slog.Info("ca cleaning", "certname", name)
+func (h *CaHandler) BulkRevokeCertificates(c *gin.Context) {
+	h.bulk(c, h.revokeCertificate)
+}
 
-	err := h.caClient.CleanCertificate(name)
+func (h *CaHandler) BulkCleanCertificates(c *gin.Context) {
+	h.bulk(c, h.cleanCertificate)
+}
 
-	if err != nil {
-		slog.Error("error cleaning certificate", "error", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+// bulk runs the operation for all requested certnames with bounded concurrency and
+// reports the result per certname, so partial failures are visible. A query needs a
+// filter, the number of certificates is limited and the certificate of the CA server is
+// never changed.
+func (h *CaHandler) bulk(c *gin.Context, operation func(user string, certname string) error) {
+	var request model.CertificateBulkRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
 		return
 	}
 
-	err = h.deactivateNode(name)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+	certnames := request.Certnames
+	certs := map[string]model.CertificateStatus{}
+
+	if request.Query != nil {
+		if request.Query.Filter == nil || strings.TrimSpace(*request.Query.Filter) == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(errors.New("a bulk query needs a filter, use certnames to select certificates explicitly")))
+			return
+		}
+
+		result, err := h.queryCertificates(*request.Query)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+			return
+		}
+
+		for _, cert := range result {
+			certnames = append(certnames, cert.Name)
+			certs[cert.Name] = cert
+		}
+	}
+
+	slices.Sort(certnames)
+	certnames = slices.Compact(certnames)
+
+	if len(certnames) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(errors.New("no certnames given")))
 		return
 	}
 
-	c.JSON(http.StatusOK, NewSuccessResponse(nil))
+	if limit := h.config.PuppetCA.BulkMaxCertificates; limit > 0 && len(certnames) > limit {
+		err := fmt.Errorf("the bulk operation would change %d certificates, more than puppetca.bulk_max_certificates (%d)", len(certnames), limit)
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	user := auditUser(c)
+	response := model.CertificateBulkResponse{
+		Results: make([]model.CertificateBulkResult, len(certnames)),
+	}
+
+	concurrency := max(h.config.PuppetCA.BulkConcurrency, 1)
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, certname := range certnames {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			result := model.CertificateBulkResult{
+				Certname: certname,
+				Success:  true,
+			}
+
+			err := h.checkNotCaServer(certname, certs)
+			if err == nil {
+				err = operation(user, certname)
+			}
+			if err != nil {
+				result.Success = false
+				result.Error = err.Error()
+			}
+
+			response.Results[i] = result
+		}()
+	}
+
+	wg.Wait()
+
+	for _, result := range response.Results {
+		if result.Success {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(response))
+}
+
+// checkNotCaServer refuses the certificate of the CA server itself, recognized by the
+// configured puppetca.host or the pp_cli_auth extension Puppet Server adds to its own
+// certificate. Certificates not selected by the query are looked up.
+func (h *CaHandler) checkNotCaServer(certname string, certs map[string]model.CertificateStatus) error {
+	errCaServer := fmt.Errorf("refusing to change %s in bulk, it is the certificate of the CA server", certname)
+
+	if certname == h.config.PuppetCA.Host {
+		return errCaServer
+	}
+
+	cert, found := certs[certname]
+	if !found {
+		status, err := h.caClient.GetCertificate(certname)
+		if err != nil {
+			// the operation itself reports the missing certificate
+			return nil
+		}
+		cert = *status
+	}
+
+	if cert.AuthorizationExtensions != nil && (*cert.AuthorizationExtensions)["pp_cli_auth"] == "true" {
+		return errCaServer
+	}
+
+	return nil
+}
+
+func (h *CaHandler) signCertificate(user string, name string) error {
+	slog.Info("ca signing", "certname", name)
+
+	prior := h.priorCertificate(name)
+	err := h.caClient.SignCertificate(name)
+	h.audit(user, model.AuditActionSign, name, prior, err, "")
+
+	if err != nil {
+		slog.Error("error signing certificate", "error", err)
+	}
+
+	return err
+}
+
+func (h *CaHandler) revokeCertificate(user string, name string) error {
+	slog.Info("ca revoking", "certname", name)
+
+	prior := h.priorCertificate(name)
+	err := h.caClient.RevokeCertificate(name)
+	h.audit(user, model.AuditActionRevoke, name, prior, err, "")
+
+	if err != nil {
+		slog.Error("error revoking certificate", "error", err)
+		return err
+	}
+
+	return h.deactivateNode(user, name)
+}
+
+func (h *CaHandler) cleanCertificate(user string, name string) error {
+	slog.Info("ca cleaning", "certname", name)
+
+	prior := h.priorCertificate(name)
+	err := h.caClient.CleanCertificate(name)
+	h.audit(user, model.AuditActionClean, name, prior, err, "")
+
+	if err != nil {
+		slog.Error("error cleaning certificate", "error", err)
+		return err
+	}
+
+	return h.deactivateNode(user, name)
 }
 
-func (h *CaHandler) deactivateNode(certname string) error {
+func (h *CaHandler) deactivateNode(user string, certname string) error {
 	if !h.config.PuppetCA.DeactivateNodes {
 		return nil
 	}
 
 	slog.Info("ca deactivating node", "certname", certname)
 
-	pdb := puppetdb.NewClient()
-	resp, err := pdb.DeactivateNode(certname)
+	resp, err := h.pdbClient.DeactivateNode(certname)
 
+	uuid := ""
 	if err != nil {
 		slog.Error("error deactivating certificate", "error", err)
 	} else {
 		slog.Info("deactivated node", "certname", certname, "uuid", resp.Uuid)
+		uuid = resp.Uuid
 	}
 
+	h.audit(user, model.AuditActionDeactivate, certname, nil, err, uuid)
+
 	return err
 }
+
+// priorCertificate returns the certificate before it gets changed, for the audit log
+func (h *CaHandler) priorCertificate(certname string) *model.CertificateStatus {
+	cert, err := h.caClient.GetCertificate(certname)
+	if err != nil {
+		slog.Warn("could not get certificate status for audit", "certname", certname, "error", err)
+		return nil
+	}
+
+	return cert
+}
+
+func (h *CaHandler) audit(user string, action string, certname string, prior *model.CertificateStatus, err error, commandUuid string) {
+	event := model.AuditEvent{
+		User:        user,
+		Action:      action,
+		Certname:    certname,
+		Outcome:     model.AuditOutcomeSuccess,
+		CommandUuid: commandUuid,
+	}
+
+	if prior != nil {
+		event.PriorState = &prior.State
+		event.PriorFingerprint = prior.Fingerprint
+	}
+
+	if err != nil {
+		event.Outcome = model.AuditOutcomeFailure
+		event.Error = err.Error()
+	}
+
+	h.auditLogger.Record(event)
+}
@@ -1,9 +1,11 @@
 package handler
 
 import (
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sebastianrakel/openvoxview/puppetdb"
 )
 
 func baseResponse() map[string]any {
@@ -25,3 +27,14 @@ func NewSuccessResponse(data interface{}) map[string]any {
 
 	return resp
 }
+
+// pdbClient returns the client without result cache, when the request asks for fresh
+// data with Cache-Control: no-cache or no-store
+func pdbClient(c *gin.Context, client *puppetdb.Client) *puppetdb.Client {
+	cacheControl := strings.ToLower(c.GetHeader("Cache-Control"))
+	if strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "no-store") {
+		return client.WithoutCache()
+	}
+
+	return client
+}
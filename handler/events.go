@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianrakel/openvoxview/events"
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+// eventKeepAlive is how often a comment is sent on an idle event stream, so proxies do
+// not close the connection
+const eventKeepAlive = 15 * time.Second
+
+type EventHandler struct {
+	watcher *events.Watcher
+}
+
+func NewEventHandler(watcher *events.Watcher) *EventHandler {
+	return &EventHandler{
+		watcher: watcher,
+	}
+}
+
+// Stream sends the fleet events matching the query as server-sent events, until the
+// client disconnects
+func (h *EventHandler) Stream(c *gin.Context) {
+	var query model.FleetEventQuery
+	if err := c.BindQuery(&query); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	if err := query.Validate(); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	subscription := h.watcher.Subscribe(query)
+	defer h.watcher.Unsubscribe(subscription)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, open := <-subscription.Events():
+			if !open {
+				return false
+			}
+			if err := writeEvent(w, event); err != nil {
+				slog.Debug("error writing event", "error", err)
+				return false
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func writeEvent(w io.Writer, event model.FleetEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+	return err
+}
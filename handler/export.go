@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"fmt"
+	"iter"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianrakel/openvoxview/export"
+)
+
+// exportFormat returns the requested export format or an empty string for json, an
+// unknown format aborts the request
+func exportFormat(c *gin.Context) (string, bool) {
+	format, err := export.Negotiate(c.Query("format"), c.GetHeader("Accept"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return "", false
+	}
+
+	return format, true
+}
+
+// attachment sets the headers of an export named like the view or query
+func attachment(c *gin.Context, format string, name string) {
+	filename := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, filename, format))
+}
+
+// startExport sends the headers of the attachment and returns the writer for its rows
+func startExport(c *gin.Context, format string, name string, columns []string) (export.Writer, error) {
+	attachment(c, format, name)
+	c.Status(http.StatusOK)
+
+	return export.NewWriter(format, c.Writer, columns)
+}
+
+// writeExport streams the rows as attachment, the response is already sent when a
+// write fails, so errors are only logged
+func writeExport(c *gin.Context, format string, name string, columns []string, rows iter.Seq[[]any]) {
+	writer, err := startExport(c, format, name, columns)
+	if err != nil {
+		slog.Error("error starting export", "format", format, "error", err)
+		return
+	}
+
+	defer func() {
+		if err := writer.Close(); err != nil {
+			slog.Error("error finishing export", "format", format, "error", err)
+		}
+	}()
+
+	count := 0
+	for row := range rows {
+		if err := writer.Write(row); err != nil {
+			slog.Error("error writing export", "format", format, "error", err)
+			return
+		}
+
+		count++
+		if count%streamFlushRows == 0 {
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/model"
+	"github.com/sebastianrakel/openvoxview/puppetdb/query"
+)
+
+// GuardrailError is a raw PQL query rejected or canceled by one of the query_limits,
+// Guardrail is the name of the option that fired
+type GuardrailError struct {
+	Guardrail string
+	Status    int
+	Message   string
+}
+
+func (e *GuardrailError) Error() string {
+	return fmt.Sprintf("query_limits.%s: %s", e.Guardrail, e.Message)
+}
+
+// queryGuard applies the query_limits to the raw PQL queries of the users
+type queryGuard struct {
+	config *config.Config
+
+	mu      sync.Mutex
+	running map[string]int
+}
+
+func newQueryGuard(cfg *config.Config) *queryGuard {
+	return &queryGuard{
+		config:  cfg,
+		running: map[string]int{},
+	}
+}
+
+// prepare validates the query and returns the PQL to send to PuppetDB, which has the
+// default limit added when the query has none. The query is sent as it is written, only
+// the limit is added.
+func (g *queryGuard) prepare(queryRequest model.QueryRequest) (string, error) {
+	limits := g.config.QueryLimits
+
+	parsed, err := query.Parse(queryRequest.Query)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entity := range parsed.Entities() {
+		if slices.Contains(limits.DeniedEntities, entity) {
+			return "", &GuardrailError{
+				Guardrail: "denied_entities",
+				Status:    http.StatusForbidden,
+				Message:   fmt.Sprintf("%s can not be queried", entity),
+			}
+		}
+	}
+
+	maxLimit := g.maxLimit()
+	if parsed.Limited() {
+		if maxLimit > 0 && parsed.Limit > maxLimit {
+			return "", &GuardrailError{
+				Guardrail: "max_limit",
+				Status:    http.StatusForbidden,
+				Message:   fmt.Sprintf("the limit %d is above the maximum of %d", parsed.Limit, maxLimit),
+			}
+		}
+		return queryRequest.Query, nil
+	}
+
+	if limits.DefaultLimit <= 0 {
+		return queryRequest.Query, nil
+	}
+
+	if queryRequest.Unlimited {
+		if !limits.AllowUnlimited {
+			return "", &GuardrailError{
+				Guardrail: "allow_unlimited",
+				Status:    http.StatusForbidden,
+				Message:   "queries without limit are not allowed, add a limit to the query",
+			}
+		}
+		return queryRequest.Query, nil
+	}
+
+	limit := limits.DefaultLimit
+	if maxLimit > 0 {
+		limit = min(limit, maxLimit)
+	}
+	return query.WithLimit(queryRequest.Query, limit)
+}
+
+// maxLimit returns the highest limit a query can have, 0 if there is none. Without
+// max_limit it is the default limit, if queries can not opt out of it.
+func (g *queryGuard) maxLimit() int {
+	limits := g.config.QueryLimits
+
+	if limits.MaxLimit > 0 {
+		return limits.MaxLimit
+	}
+	if !limits.AllowUnlimited {
+		return limits.DefaultLimit
+	}
+	return 0
+}
+
+// acquire reserves one of the concurrent queries of the user, release has to be called
+// when the query is done
+func (g *queryGuard) acquire(user string) (func(), error) {
+	maxConcurrent := g.config.QueryLimits.MaxConcurrent
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if maxConcurrent > 0 && g.running[user] >= maxConcurrent {
+		return nil, &GuardrailError{
+			Guardrail: "max_concurrent",
+			Status:    http.StatusTooManyRequests,
+			Message:   fmt.Sprintf("%d queries are already running, wait until one of them is done", g.running[user]),
+		}
+	}
+
+	g.running[user]++
+
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		g.running[user]--
+		if g.running[user] <= 0 {
+			delete(g.running, user)
+		}
+	}, nil
+}
+
+// timeout returns the name and the duration of the timeout of a query, exports write
+// all rows and have their own timeout
+func (g *queryGuard) timeout(export bool) (string, time.Duration) {
+	if export {
+		return "export_timeout", g.config.QueryLimits.ExportTimeout
+	}
+	return "timeout", g.config.QueryLimits.Timeout
+}
+
+// context returns the request context with the query or export timeout, so the query
+// is also canceled when the client goes away
+func (g *queryGuard) context(parent context.Context, export bool) (context.Context, context.CancelFunc) {
+	_, timeout := g.timeout(export)
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// queryErrorStatus is the status of guardrail and syntax errors, every other error has
+// the fallback status
+func queryErrorStatus(err error, fallback int) int {
+	var guardrailErr *GuardrailError
+	if errors.As(err, &guardrailErr) {
+		return guardrailErr.Status
+	}
+
+	var syntaxErr *query.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return http.StatusBadRequest
+	}
+
+	return fallback
+}
+
+// timeoutError replaces the error of a query whose context from context ran into the
+// timeout, other deadlines like puppetdb.timeout keep their error
+func (g *queryGuard) timeoutError(ctx context.Context, export bool, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	guardrail, timeout := g.timeout(export)
+	return &GuardrailError{
+		Guardrail: guardrail,
+		Status:    http.StatusGatewayTimeout,
+		Message:   fmt.Sprintf("the query did not finish within %s", timeout),
+	}
+}
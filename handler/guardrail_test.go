@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/model"
+	"github.com/sebastianrakel/openvoxview/puppetdb/query"
+)
+
+func guardrailConfig(defaultLimit int, maxLimit int, allowUnlimited bool, maxConcurrent int) *config.Config {
+	cfg := &config.Config{}
+	cfg.QueryLimits.DefaultLimit = defaultLimit
+	cfg.QueryLimits.MaxLimit = maxLimit
+	cfg.QueryLimits.AllowUnlimited = allowUnlimited
+	cfg.QueryLimits.DeniedEntities = []string{"resources"}
+	cfg.QueryLimits.MaxConcurrent = maxConcurrent
+	return cfg
+}
+
+func TestQueryGuardPrepare(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    *config.Config
+		query     model.QueryRequest
+		want      string
+		guardrail string
+	}{
+		{"default limit added", guardrailConfig(1000, 0, true, 0), model.QueryRequest{Query: `nodes { certname ~ 'a\"b' }`}, `nodes { certname ~ 'a\"b' limit 1000 }`, ""},
+		{"default limit before offset", guardrailConfig(1000, 0, true, 0), model.QueryRequest{Query: `reports { offset 10 }`}, `reports { limit 1000 offset 10 }`, ""},
+		{"own limit kept", guardrailConfig(1000, 0, true, 0), model.QueryRequest{Query: `nodes { limit 5000 }`}, `nodes { limit 5000 }`, ""},
+		{"limit 0 kept", guardrailConfig(1000, 0, true, 0), model.QueryRequest{Query: `nodes { limit 0 }`}, `nodes { limit 0 }`, ""},
+		{"no default limit", guardrailConfig(0, 0, true, 0), model.QueryRequest{Query: `nodes`}, `nodes`, ""},
+		{"unlimited allowed", guardrailConfig(1000, 0, true, 0), model.QueryRequest{Query: `nodes {}`, Unlimited: true}, `nodes {}`, ""},
+		{"unlimited denied", guardrailConfig(1000, 0, false, 0), model.QueryRequest{Query: `nodes {}`, Unlimited: true}, "", "allow_unlimited"},
+		{"limit above default without unlimited", guardrailConfig(1000, 0, false, 0), model.QueryRequest{Query: `nodes { limit 1001 }`}, "", "max_limit"},
+		{"limit at default without unlimited", guardrailConfig(1000, 0, false, 0), model.QueryRequest{Query: `nodes { limit 1000 }`}, `nodes { limit 1000 }`, ""},
+		{"limit above max limit", guardrailConfig(1000, 5000, true, 0), model.QueryRequest{Query: `nodes { limit 5001 }`}, "", "max_limit"},
+		{"limit below max limit", guardrailConfig(1000, 5000, false, 0), model.QueryRequest{Query: `nodes { limit 5000 }`}, `nodes { limit 5000 }`, ""},
+		{"default limit capped by max limit", guardrailConfig(1000, 500, true, 0), model.QueryRequest{Query: `nodes`}, `nodes { limit 500 }`, ""},
+		{"denied entity", guardrailConfig(1000, 0, true, 0), model.QueryRequest{Query: `resources {}`}, "", "denied_entities"},
+		{"denied entity in subquery", guardrailConfig(1000, 0, true, 0), model.QueryRequest{Query: `nodes { resources { type = "User" } }`}, "", "denied_entities"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pql, err := newQueryGuard(test.config).prepare(test.query)
+
+			if test.guardrail != "" {
+				var guardrailErr *GuardrailError
+				if !errors.As(err, &guardrailErr) || guardrailErr.Guardrail != test.guardrail {
+					t.Fatalf("error = %v, want the guardrail %s", err, test.guardrail)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pql != test.want {
+				t.Errorf("prepare = %s, want %s", pql, test.want)
+			}
+		})
+	}
+}
+
+func TestQueryGuardPrepareSyntaxError(t *testing.T) {
+	_, err := newQueryGuard(guardrailConfig(1000, 0, true, 0)).prepare(model.QueryRequest{Query: `nodes {`})
+
+	var syntaxErr *query.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("error = %v, want a syntax error", err)
+	}
+}
+
+func TestQueryGuardAcquire(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxConcurrent int
+		// steps acquire a query of the user or release the oldest running one with "-user",
+		// want is whether the acquire succeeds and ignored for releases
+		steps []string
+		want  []bool
+	}{
+		{"unlimited", 0, []string{"alice", "alice", "alice"}, []bool{true, true, true}},
+		{"limit per user", 2, []string{"alice", "alice", "alice", "bob"}, []bool{true, true, false, true}},
+		{"released", 1, []string{"alice", "alice", "-alice", "alice"}, []bool{true, false, true, true}},
+		{"users independent", 1, []string{"alice", "bob", "alice", "-bob", "bob", "alice"}, []bool{true, true, false, true, true, false}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			guard := newQueryGuard(guardrailConfig(1000, 0, true, test.maxConcurrent))
+			releases := map[string][]func(){}
+
+			for i, step := range test.steps {
+				if user, isRelease := strings.CutPrefix(step, "-"); isRelease {
+					if len(releases[user]) > 0 {
+						releases[user][0]()
+						releases[user] = releases[user][1:]
+					}
+					continue
+				}
+
+				release, err := guard.acquire(step)
+				if acquired := err == nil; acquired != test.want[i] {
+					t.Fatalf("step %d: acquire of %s = %v, want %v", i, step, err, test.want[i])
+				}
+				if err != nil {
+					var guardrailErr *GuardrailError
+					if !errors.As(err, &guardrailErr) || guardrailErr.Guardrail != "max_concurrent" {
+						t.Errorf("step %d: error = %v, want the guardrail max_concurrent", i, err)
+					}
+					continue
+				}
+				releases[step] = append(releases[step], release)
+			}
+
+			for _, userReleases := range releases {
+				for _, release := range userReleases {
+					release()
+				}
+			}
+			if len(guard.running) != 0 {
+				t.Errorf("running after releasing all queries = %v, want none", guard.running)
+			}
+		})
+	}
+}
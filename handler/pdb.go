@@ -1,88 +1,225 @@
 package handler
 
 import (
-	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/history"
+	"github.com/sebastianrakel/openvoxview/model"
 	"github.com/sebastianrakel/openvoxview/puppetdb"
+	"github.com/sebastianrakel/openvoxview/puppetdb/query"
 )
 
-type QueryRequest struct {
-	Query         string
-	SaveInHistory bool
-}
-
-type QueryResult struct {
-	Data                 []json.RawMessage
-	Error                error
-	Success              bool
-	ExecutedOn           time.Time
-	ExecutionTimeInMilli int64
-	Count                int
-}
-
-type PqlHistoryEntry struct {
-	Query  QueryRequest
-	Result QueryResult
-}
-
 type PdbHandler struct {
-	QueryHistory []PqlHistoryEntry
 	config       *config.Config
+	pdbClient    *puppetdb.Client
+	historyStore history.Store
+	queryGuard   *queryGuard
 }
 
-func NewPdbHandler(config *config.Config) *PdbHandler {
+func NewPdbHandler(config *config.Config, pdbClient *puppetdb.Client, historyStore history.Store) *PdbHandler {
 	return &PdbHandler{
-		QueryHistory: []PqlHistoryEntry{},
 		config:       config,
+		pdbClient:    pdbClient,
+		historyStore: historyStore,
+		queryGuard:   newQueryGuard(config),
 	}
 }
 
 func (h *PdbHandler) PdbExecuteQuery(c *gin.Context) {
-	var queryRequest QueryRequest
+	var queryRequest model.QueryRequest
 	c.BindJSON(&queryRequest)
 
-	dbClient := puppetdb.NewClient()
-	slog.Debug("executing query", "query", queryRequest.Query)
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
 
-	historyEntry := PqlHistoryEntry{
-		Query: queryRequest,
+	// syntax errors and guardrails are reported before anything is sent to PuppetDB
+	pql, err := h.queryGuard.prepare(queryRequest)
+	if err != nil {
+		h.rejectQuery(c, queryRequest, err)
+		return
 	}
 
+	release, err := h.queryGuard.acquire(userName(c))
+	if err != nil {
+		h.rejectQuery(c, queryRequest, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := h.queryGuard.context(c.Request.Context(), format != "")
+	defer cancel()
+
+	// exports are always streamed, so their size is not limited by the memory
+	if stream, _ := strconv.ParseBool(c.Query("stream")); stream || format != "" {
+		h.streamQuery(ctx, c, queryRequest, pql, format)
+		return
+	}
+
+	slog.Debug("executing query", "query", pql)
+
 	start := time.Now()
-	res, code, err := dbClient.Query(queryRequest.Query)
+	res, code, err := pdbClient(c, h.pdbClient).Query(ctx, pql)
+	err = h.queryGuard.timeoutError(ctx, false, err)
 	end := time.Now()
 
 	duration := end.Sub(start).Milliseconds()
 
-	queryResult := QueryResult{
+	queryResult := model.QueryResult{
 		Data:                 res,
-		Error:                err,
 		Success:              err == nil,
 		ExecutedOn:           time.Now(),
 		ExecutionTimeInMilli: duration,
 		Count:                len(res),
 	}
 
-	historyEntry.Result = queryResult
-
-	if queryRequest.SaveInHistory {
-		h.QueryHistory = append(h.QueryHistory, historyEntry)
+	if err != nil {
+		queryResult.Error = err.Error()
 	}
+
+	h.saveHistory(c, queryRequest, queryResult)
 	if err != nil {
-		c.AbortWithStatusJSON(code, NewErrorResponse(err))
+		c.AbortWithStatusJSON(queryErrorStatus(err, code), NewErrorResponse(err))
 		return
 	}
 
 	c.JSON(http.StatusOK, NewSuccessResponse(queryResult))
 }
 
+// rejectQuery answers a query that was not sent to PuppetDB because of a syntax error or
+// a guardrail, it is still kept in the history
+func (h *PdbHandler) rejectQuery(c *gin.Context, queryRequest model.QueryRequest, err error) {
+	h.saveHistory(c, queryRequest, model.QueryResult{
+		Error:      err.Error(),
+		ExecutedOn: time.Now(),
+	})
+	c.AbortWithStatusJSON(queryErrorStatus(err, http.StatusBadRequest), NewErrorResponse(err))
+}
+
+// saveHistory keeps the query and its result without the data, if the user asked for it
+func (h *PdbHandler) saveHistory(c *gin.Context, queryRequest model.QueryRequest, queryResult model.QueryResult) {
+	if !queryRequest.SaveInHistory {
+		return
+	}
+
+	queryResult.Data = nil
+
+	_, err := h.historyStore.Add(model.QueryHistoryEntry{
+		User:   userName(c),
+		Query:  queryRequest,
+		Result: queryResult,
+	})
+	if err != nil {
+		slog.Error("error saving query history", "error", err)
+	}
+}
+
+type QueryValidation struct {
+	Query string
+	AST   []any
+}
+
+// PdbValidateQuery parses the query without executing it and returns it normalized and
+// in the AST syntax
+func (h *PdbHandler) PdbValidateQuery(c *gin.Context) {
+	var queryRequest model.QueryRequest
+	if err := c.BindJSON(&queryRequest); err != nil {
+		return
+	}
+
+	parsed, err := query.Parse(queryRequest.Query)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(QueryValidation{
+		Query: parsed.String(),
+		AST:   parsed.AST(),
+	}))
+}
+
+type QueryHistoryQuery struct {
+	Offset int  `form:"offset" binding:"min=0"`
+	Limit  int  `form:"limit" binding:"min=0"`
+	Pinned bool `form:"pinned"`
+}
+
 func (h *PdbHandler) PdbQueryHistory(c *gin.Context) {
-	c.JSON(http.StatusOK, NewSuccessResponse(h.QueryHistory))
+	var query QueryHistoryQuery
+	if err := c.BindQuery(&query); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	entries, total, err := h.historyStore.List(userName(c), history.ListQuery{
+		Offset:     query.Offset,
+		Limit:      query.Limit,
+		PinnedOnly: query.Pinned,
+	})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	response := model.QueryHistoryResponse{
+		Entries: entries,
+		Total:   total,
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(response))
+}
+
+func (h *PdbHandler) PdbQueryHistoryDelete(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	h.historyResult(c, h.historyStore.Delete(userName(c), id))
+}
+
+func (h *PdbHandler) PdbQueryHistoryClear(c *gin.Context) {
+	h.historyResult(c, h.historyStore.Clear(userName(c)))
+}
+
+func (h *PdbHandler) PdbQueryHistoryPin(c *gin.Context) {
+	h.setPinned(c, true)
+}
+
+func (h *PdbHandler) PdbQueryHistoryUnpin(c *gin.Context) {
+	h.setPinned(c, false)
+}
+
+func (h *PdbHandler) setPinned(c *gin.Context, pinned bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	h.historyResult(c, h.historyStore.SetPinned(userName(c), id, pinned))
+}
+
+func (h *PdbHandler) historyResult(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, history.ErrNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(err))
+	case errors.Is(err, history.ErrPinnedLimit):
+		c.AbortWithStatusJSON(http.StatusConflict, NewErrorResponse(err))
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+	default:
+		c.JSON(http.StatusOK, NewSuccessResponse(nil))
+	}
 }
 
 func (h *PdbHandler) PdbQueryPredefined(c *gin.Context) {
@@ -96,9 +233,7 @@ func (h *PdbHandler) PdbQueryPredefined(c *gin.Context) {
 }
 
 func (h *PdbHandler) PdbGetFactNames(c *gin.Context) {
-	dbClient := puppetdb.NewClient()
-
-	res, err := dbClient.GetFactNames()
+	res, err := pdbClient(c, h.pdbClient).GetFactNames()
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
 		return
@@ -115,9 +250,7 @@ func (h *PdbHandler) PdbGetEventCounts(c *gin.Context) {
 		return
 	}
 
-	dbClient := puppetdb.NewClient()
-
-	res, err := dbClient.GetEventCounts(&query)
+	res, err := pdbClient(c, h.pdbClient).GetEventCounts(&query)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
 		return
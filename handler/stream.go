@@ -0,0 +1,314 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianrakel/openvoxview/export"
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+// streamFlushRows is the number of rows after which the response is flushed to the client
+const streamFlushRows = 1000
+
+// rowSink writes the rows of a streamed query, the status is sent with the first write,
+// so everything that happens afterwards is reported by finish
+type rowSink interface {
+	row(row json.RawMessage) error
+	finish(result model.QueryResult) error
+}
+
+// streamQuery passes the rows of PuppetDB's response through as they are read, instead
+// of holding the whole result in memory. The upstream request is canceled with the
+// context, when the client goes away or the query timeout is reached.
+func (h *PdbHandler) streamQuery(ctx context.Context, c *gin.Context, queryRequest model.QueryRequest, pql string, format string) {
+	slog.Debug("streaming query", "query", pql)
+
+	start := time.Now()
+	body, code, err := pdbClient(c, h.pdbClient).QueryStream(ctx, pql)
+	err = h.queryGuard.timeoutError(ctx, format != "", err)
+	if err != nil {
+		h.saveHistory(c, queryRequest, model.QueryResult{
+			Error:                err.Error(),
+			ExecutedOn:           time.Now(),
+			ExecutionTimeInMilli: time.Since(start).Milliseconds(),
+		})
+		c.AbortWithStatusJSON(queryErrorStatus(err, code), NewErrorResponse(err))
+		return
+	}
+	defer body.Close()
+
+	var sink rowSink
+	switch format {
+	case "":
+		sink = &jsonSink{c: c}
+	case export.FORMAT_NDJSON:
+		sink = newNdjsonSink(c)
+	default:
+		sink = newExportSink(c, format)
+	}
+
+	count, truncated, err := copyRows(body, sink, h.config.PuppetDB.StreamMaxRows)
+	err = h.queryGuard.timeoutError(ctx, format != "", err)
+
+	queryResult := model.QueryResult{
+		Success:              err == nil,
+		ExecutedOn:           time.Now(),
+		ExecutionTimeInMilli: time.Since(start).Milliseconds(),
+		Count:                count,
+		Truncated:            truncated,
+	}
+
+	if err != nil {
+		queryResult.Error = err.Error()
+		slog.Debug("query stream ended early", "rows", count, "error", err)
+	}
+
+	if err := sink.finish(queryResult); err != nil {
+		slog.Debug("error finishing query stream", "error", err)
+	}
+
+	h.saveHistory(c, queryRequest, queryResult)
+}
+
+// copyRows decodes the json array of the response row by row and stops after maxRows,
+// if maxRows is greater than zero
+func copyRows(body io.Reader, sink rowSink, maxRows int) (int, bool, error) {
+	decoder := json.NewDecoder(body)
+
+	token, err := decoder.Token()
+	if err != nil {
+		return 0, false, err
+	}
+	if delim, isDelim := token.(json.Delim); !isDelim || delim != '[' {
+		return 0, false, errors.New("puppetdb did not respond with a list")
+	}
+
+	count := 0
+	for decoder.More() {
+		if maxRows > 0 && count >= maxRows {
+			return count, true, nil
+		}
+
+		var row json.RawMessage
+		if err := decoder.Decode(&row); err != nil {
+			return count, false, err
+		}
+
+		if err := sink.row(row); err != nil {
+			return count, false, err
+		}
+		count++
+	}
+
+	return count, false, nil
+}
+
+// jsonSink writes the same envelope as the regular query response, the rows come first
+// and the remaining fields of the result follow once they are known
+type jsonSink struct {
+	c     *gin.Context
+	count int
+}
+
+func (s *jsonSink) start() error {
+	s.c.Header("Content-Type", "application/json; charset=utf-8")
+	s.c.Status(http.StatusOK)
+	_, err := io.WriteString(s.c.Writer, `{"Data":{"Data":[`)
+	return err
+}
+
+func (s *jsonSink) row(row json.RawMessage) error {
+	if s.count == 0 {
+		if err := s.start(); err != nil {
+			return err
+		}
+	} else if _, err := io.WriteString(s.c.Writer, ","); err != nil {
+		return err
+	}
+
+	if _, err := s.c.Writer.Write(row); err != nil {
+		return err
+	}
+
+	s.count++
+	if s.count%streamFlushRows == 0 {
+		s.c.Writer.Flush()
+	}
+
+	return nil
+}
+
+func (s *jsonSink) finish(result model.QueryResult) error {
+	if s.count == 0 {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+
+	tail, err := json.Marshal(struct {
+		Error                string
+		Success              bool
+		ExecutedOn           time.Time
+		ExecutionTimeInMilli int64
+		Count                int
+		Truncated            bool
+	}{
+		Error:                result.Error,
+		Success:              result.Success,
+		ExecutedOn:           result.ExecutedOn,
+		ExecutionTimeInMilli: result.ExecutionTimeInMilli,
+		Count:                result.Count,
+		Truncated:            result.Truncated,
+	})
+	if err != nil {
+		return err
+	}
+
+	// tail without its opening brace continues the result object started in start
+	_, err = fmt.Fprintf(s.c.Writer, `],%s,"Timestamp":%d}`, tail[1:], time.Now().Unix())
+	s.c.Writer.Flush()
+
+	return err
+}
+
+// ndjsonSink writes one row per line, the row count, truncation and errors are sent as
+// trailers since there is no envelope to put them in
+type ndjsonSink struct {
+	c     *gin.Context
+	count int
+}
+
+const (
+	trailerRows      = "X-Rows"
+	trailerTruncated = "X-Truncated"
+	trailerError     = "X-Error"
+)
+
+func newNdjsonSink(c *gin.Context) *ndjsonSink {
+	attachment(c, export.FORMAT_NDJSON, "query")
+	c.Header("Trailer", fmt.Sprintf("%s, %s, %s", trailerRows, trailerTruncated, trailerError))
+
+	return &ndjsonSink{c: c}
+}
+
+func (s *ndjsonSink) row(row json.RawMessage) error {
+	if _, err := s.c.Writer.Write(row); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(s.c.Writer, "\n"); err != nil {
+		return err
+	}
+
+	s.count++
+	if s.count%streamFlushRows == 0 {
+		s.c.Writer.Flush()
+	}
+
+	return nil
+}
+
+func (s *ndjsonSink) finish(result model.QueryResult) error {
+	s.c.Status(http.StatusOK)
+	s.c.Writer.WriteHeaderNow()
+
+	header := s.c.Writer.Header()
+	header.Set(trailerRows, strconv.Itoa(result.Count))
+	header.Set(trailerTruncated, strconv.FormatBool(result.Truncated))
+	if result.Error != "" {
+		header.Set(trailerError, result.Error)
+	}
+
+	s.c.Writer.Flush()
+	return nil
+}
+
+// exportSink writes the rows as csv or xlsx attachment. The columns are the keys of the
+// first row, all rows of a PuppetDB response have the same keys. Like with ndjson the
+// row count, truncation and errors are sent as trailers.
+type exportSink struct {
+	c       *gin.Context
+	format  string
+	writer  export.Writer
+	columns []string
+	count   int
+}
+
+func newExportSink(c *gin.Context, format string) *exportSink {
+	c.Header("Trailer", fmt.Sprintf("%s, %s, %s", trailerRows, trailerTruncated, trailerError))
+
+	return &exportSink{c: c, format: format}
+}
+
+func (s *exportSink) start(columns []string) error {
+	writer, err := startExport(s.c, s.format, "query", columns)
+	if err != nil {
+		return err
+	}
+
+	s.writer = writer
+	s.columns = columns
+	return nil
+}
+
+func (s *exportSink) row(row json.RawMessage) error {
+	if s.writer == nil {
+		if err := s.start(export.ObjectColumns([]json.RawMessage{row})); err != nil {
+			return err
+		}
+	}
+
+	object := map[string]json.RawMessage{}
+	if err := json.Unmarshal(row, &object); err != nil {
+		return err
+	}
+
+	values := make([]any, len(s.columns))
+	for i, column := range s.columns {
+		if value, exists := object[column]; exists {
+			values[i] = value
+		}
+	}
+
+	if err := s.writer.Write(values); err != nil {
+		return err
+	}
+
+	s.count++
+	if s.count%streamFlushRows == 0 {
+		s.c.Writer.Flush()
+	}
+
+	return nil
+}
+
+func (s *exportSink) finish(result model.QueryResult) error {
+	if s.writer == nil {
+		if err := s.start([]string{}); err != nil {
+			return err
+		}
+	}
+
+	err := s.writer.Close()
+	if err != nil && result.Error == "" {
+		result.Error = err.Error()
+	}
+
+	header := s.c.Writer.Header()
+	header.Set(trailerRows, strconv.Itoa(result.Count))
+	header.Set(trailerTruncated, strconv.FormatBool(result.Truncated))
+	if result.Error != "" {
+		header.Set(trailerError, result.Error)
+	}
+
+	s.c.Writer.Flush()
+	return err
+}
@@ -2,24 +2,57 @@ package handler
 
 import (
 	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
 	"net/http"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/diff"
 	"github.com/sebastianrakel/openvoxview/model"
+	"github.com/sebastianrakel/openvoxview/puppetca"
 	"github.com/sebastianrakel/openvoxview/puppetdb"
+	"github.com/sebastianrakel/openvoxview/puppetdb/query"
 )
 
 type ViewHandler struct {
-	config *config.Config
+	config          *config.Config
+	pdbClient       *puppetdb.Client
+	caClient        *puppetca.Client
+	stripPathPrefix *regexp.Regexp
 }
 
-func NewViewHandler(config *config.Config) *ViewHandler {
-	return &ViewHandler{
-		config: config,
+// NewViewHandler creates the handler of the views, caClient is nil when no Puppet CA
+// is configured
+func NewViewHandler(config *config.Config, pdbClient *puppetdb.Client, caClient *puppetca.Client) (*ViewHandler, error) {
+	h := &ViewHandler{
+		config:    config,
+		pdbClient: pdbClient,
+		caClient:  caClient,
 	}
+
+	if config.StripPathPrefix != "" {
+		stripPathPrefix, err := regexp.Compile(config.StripPathPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid strip_path_prefix: %w", err)
+		}
+		h.stripPathPrefix = stripPathPrefix
+	}
+
+	for _, view := range config.Views {
+		if slices.ContainsFunc(view.Facts, func(fact model.ViewFact) bool { return fact.Name == model.ViewCertnameColumn }) {
+			return nil, fmt.Errorf("view %q: the fact name %q is reserved for the certname column", view.Name, model.ViewCertnameColumn)
+		}
+	}
+
+	return h, nil
 }
 
 type NodesOverviewQuery struct {
@@ -32,6 +65,8 @@ func (n *NodesOverviewQuery) HasEnvironment() bool {
 }
 
 func (h *ViewHandler) NodesOverview(c *gin.Context) {
+	client := pdbClient(c, h.pdbClient)
+
 	var nodesOverviewQuery NodesOverviewQuery
 	err := c.BindQuery(&nodesOverviewQuery)
 	if err != nil {
@@ -39,8 +74,6 @@ func (h *ViewHandler) NodesOverview(c *gin.Context) {
 		return
 	}
 
-	dbClient := puppetdb.NewClient()
-
 	eventCountsQuery := puppetdb.PdbQuery{
 		Query: []any{
 			"=",
@@ -50,44 +83,39 @@ func (h *ViewHandler) NodesOverview(c *gin.Context) {
 		SummarizeBy: "certname",
 	}
 
-	eventCounts, err := dbClient.GetEventCounts(&eventCountsQuery)
+	eventCounts, err := client.GetEventCounts(&eventCountsQuery)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
 		return
 	}
 
-	nodesQuery := &puppetdb.PdbQuery{}
-
-	if nodesOverviewQuery.HasEnvironment() {
-		nodesQuery.Query = []any{"=", "catalog_environment", nodesOverviewQuery.Environment}
-	}
-
-	if len(nodesOverviewQuery.Status) > 0 {
-		statusQueries := []any(nil)
+	// unreported is computed from the report timestamp, with it every status is fetched
+	// and the nodes are filtered afterwards
+	filterUnreported := slices.Contains(nodesOverviewQuery.Status, model.NodeStatusUnreported)
 
+	statusQueries := []query.Expr{}
+	if !filterUnreported {
 		for _, status := range nodesOverviewQuery.Status {
-			statusQuery := []any{"=", "latest_report_status", status}
-
-			if statusQueries == nil {
-				statusQueries = statusQuery
-			} else {
-				statusQueries = []any{"or", statusQueries, statusQuery}
-			}
+			statusQueries = append(statusQueries, query.Equal("latest_report_status", status))
 		}
+	}
 
-		if nodesQuery.Query == nil {
-			nodesQuery.Query = statusQueries
-		} else {
-			nodesQuery.Query = []any{"and", nodesQuery.Query, statusQueries}
-		}
+	var environmentQuery query.Expr
+	if nodesOverviewQuery.HasEnvironment() {
+		environmentQuery = query.Equal("catalog_environment", nodesOverviewQuery.Environment)
 	}
 
-	nodes, err := dbClient.GetNodes(nodesQuery)
+	nodesQuery := &puppetdb.PdbQuery{
+		Query: query.ToAST(query.And(environmentQuery, query.Or(statusQueries...))),
+	}
+
+	nodes, err := client.GetNodes(nodesQuery)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
 		return
 	}
 
+	now := time.Now()
 	for i, node := range nodes {
 		eventIndex := slices.IndexFunc(eventCounts, func(n model.EventCount) bool {
 			return n.Subject.Title == node.Name
@@ -96,21 +124,555 @@ func (h *ViewHandler) NodesOverview(c *gin.Context) {
 		if eventIndex >= 0 {
 			nodes[i].Events = eventCounts[eventIndex]
 		}
+
+		nodes[i].Unreported = node.IsUnreported(now, h.config.UnreportedHours)
+		nodes[i].HoursSinceReport = node.ReportAgeHours(now)
+	}
+
+	if filterUnreported {
+		nodes = slices.DeleteFunc(nodes, func(node model.Node) bool {
+			return !node.Unreported && !slices.Contains(nodesOverviewQuery.Status, node.LatestReportStatus)
+		})
 	}
 
 	c.JSON(http.StatusOK, NewSuccessResponse(nodes))
 }
 
-func (h *ViewHandler) Metrics(c *gin.Context) {
-	environment := c.Query("environment")
+// NodeDetail returns the node with the event counts of its latest report, the report
+// itself, facts, catalog and certificate, all parts are fetched concurrently
+func (h *ViewHandler) NodeDetail(c *gin.Context) {
+	client := pdbClient(c, h.pdbClient)
+
+	certname := c.Param("certname")
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		node    *model.Node
+		nodeErr error
+		events  model.EventCount
+	)
+
+	detail := model.NodeDetail{
+		Errors: map[string]string{},
+	}
+
+	fetch := func(part string, fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(); err != nil {
+				slog.Error("error fetching node detail", "certname", certname, "part", part, "error", err)
+				mu.Lock()
+				detail.Errors[part] = err.Error()
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		node, nodeErr = client.GetNode(certname)
+	}()
+
+	fetch("latest_report", func() (err error) {
+		detail.LatestReport, err = client.GetLatestReport(certname)
+		return err
+	})
+
+	fetch("events", func() error {
+		eventCounts, err := client.GetEventCounts(&puppetdb.PdbQuery{
+			Query: []any{
+				"and",
+				[]any{"=", "certname", certname},
+				[]any{"=", "latest_report?", true},
+			},
+			SummarizeBy: "certname",
+		})
+		if len(eventCounts) > 0 {
+			events = eventCounts[0]
+		}
+		return err
+	})
+
+	fetch("facts", func() error {
+		facts, err := client.GetFacts(&puppetdb.PdbQuery{
+			Query: []any{"=", "certname", certname},
+		})
+		detail.Facts = make(map[string]any, len(facts))
+		for _, fact := range facts {
+			detail.Facts[fact.Name] = fact.Value
+		}
+		return err
+	})
+
+	fetch("catalog", func() (err error) {
+		detail.Catalog, err = client.GetCatalog(certname)
+		if detail.Catalog != nil {
+			detail.Catalog.Resources = nil
+			detail.Catalog.Edges = nil
+		}
+		return err
+	})
+
+	if h.caClient != nil {
+		fetch("certificate", func() (err error) {
+			detail.Certificate, err = h.caClient.GetCertificate(certname)
+			return err
+		})
+	}
+
+	wg.Wait()
+
+	if nodeErr != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(nodeErr))
+		return
+	}
+
+	if node == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(errors.New("node does not exists")))
+		return
+	}
+
+	detail.Node = *node
+	detail.Node.Events = events
+
+	c.JSON(http.StatusOK, NewSuccessResponse(detail))
+}
+
+const defaultReportsLimit = 50
+
+// reportSummaryFields are extracted for the report list, the metrics, logs and events
+// are only returned for a single report
+var reportSummaryFields = []any{
+	"hash", "certname", "puppet_version", "report_format", "configuration_version",
+	"start_time", "end_time", "producer_timestamp", "receive_time", "producer",
+	"transaction_uuid", "catalog_uuid", "code_id", "job_id", "cached_catalog_status",
+	"noop", "noop_pending", "corrective_change", "environment", "status", "type",
+}
+
+var reportOrderFields = []string{
+	"certname", "status", "environment", "start_time", "end_time", "receive_time",
+	"producer_timestamp", "puppet_version", "configuration_version",
+}
+
+type ReportsQuery struct {
+	Certname         string    `form:"certname"`
+	Status           []string  `form:"status"`
+	Environment      string    `form:"environment"`
+	Noop             *bool     `form:"noop"`
+	CorrectiveChange *bool     `form:"corrective_change"`
+	Since            time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until            time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	Offset           int       `form:"offset" binding:"min=0"`
+	Limit            int       `form:"limit" binding:"min=0,max=1000"`
+	OrderBy          string    `form:"order_by"`
+	Order            string    `form:"order" binding:"omitempty,oneof=asc desc"`
+}
+
+// pdbQuery converts the filters into a PuppetDB reports query, the time range applies
+// to the start time of the run
+func (q *ReportsQuery) pdbQuery() (*puppetdb.PdbQuery, error) {
+	conditions := []query.Expr{}
+
+	if q.Certname != "" {
+		conditions = append(conditions, query.Equal("certname", q.Certname))
+	}
+
+	if q.Environment != "" && q.Environment != "*" {
+		conditions = append(conditions, query.Equal("environment", q.Environment))
+	}
+
+	statusQueries := []query.Expr{}
+	for _, status := range q.Status {
+		statusQueries = append(statusQueries, query.Equal("status", status))
+	}
+	conditions = append(conditions, query.Or(statusQueries...))
+
+	if q.Noop != nil {
+		conditions = append(conditions, query.Equal("noop", *q.Noop))
+	}
+
+	if q.CorrectiveChange != nil {
+		conditions = append(conditions, query.Equal("corrective_change", *q.CorrectiveChange))
+	}
+
+	if !q.Since.IsZero() {
+		conditions = append(conditions, query.GreaterThanEqual("start_time", q.Since.Format(time.RFC3339)))
+	}
+
+	if !q.Until.IsZero() {
+		conditions = append(conditions, query.LessThan("start_time", q.Until.Format(time.RFC3339)))
+	}
+
+	extract := []any{"extract", reportSummaryFields}
+	if where := query.And(conditions...); where != nil {
+		extract = append(extract, where.AST())
+	}
+
+	orderBy := puppetdb.PdbOrderBy{
+		Field: "start_time",
+		Order: "desc",
+	}
+
+	if q.OrderBy != "" {
+		if !slices.Contains(reportOrderFields, q.OrderBy) {
+			return nil, fmt.Errorf("reports can not be ordered by %s", q.OrderBy)
+		}
+		orderBy = puppetdb.PdbOrderBy{
+			Field: q.OrderBy,
+			Order: "asc",
+		}
+	}
+
+	if q.Order != "" {
+		orderBy.Order = q.Order
+	}
+
+	limit := q.Limit
+	if limit == 0 {
+		limit = defaultReportsLimit
+	}
+
+	return &puppetdb.PdbQuery{
+		Query:        extract,
+		Limit:        limit,
+		Offset:       q.Offset,
+		OrderBy:      []puppetdb.PdbOrderBy{orderBy},
+		IncludeTotal: true,
+	}, nil
+}
+
+// Reports returns a page of report summaries, newest first by default
+func (h *ViewHandler) Reports(c *gin.Context) {
+	var reportsQuery ReportsQuery
+	if err := c.BindQuery(&reportsQuery); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	query, err := reportsQuery.pdbQuery()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
 
-	dbClient := puppetdb.NewClient()
+	reports, total, err := pdbClient(c, h.pdbClient).GetReports(query)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	if reports == nil {
+		reports = []model.Report{}
+	}
+
+	response := model.ReportsResponse{
+		Reports: reports,
+		Total:   total,
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(response))
+}
+
+// Report returns a single report with its metrics, logs and events
+func (h *ViewHandler) Report(c *gin.Context) {
+	report, err := pdbClient(c, h.pdbClient).GetReport(c.Param("hash"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	if report == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(errors.New("report does not exists")))
+		return
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(report))
+}
+
+type ReportDiffQuery struct {
+	From     string `form:"from"`
+	To       string `form:"to"`
+	Certname string `form:"certname"`
+}
+
+// ReportDiff compares two reports. Without to the latest report of the node given by
+// certname is used, without from the report before to.
+func (h *ViewHandler) ReportDiff(c *gin.Context) {
+	client := pdbClient(c, h.pdbClient)
+
+	var diffQuery ReportDiffQuery
+	if err := c.BindQuery(&diffQuery); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	if diffQuery.To == "" {
+		if diffQuery.Certname == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(errors.New("to or certname is required")))
+			return
+		}
+
+		node, err := client.GetNode(diffQuery.Certname)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+			return
+		}
+
+		if node == nil || node.LatestReportHash == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(errors.New("node has no report")))
+			return
+		}
+
+		diffQuery.To = node.LatestReportHash
+	}
+
+	to, err := client.GetReport(diffQuery.To)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	if to == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(fmt.Errorf("report %s does not exists", diffQuery.To)))
+		return
+	}
 
-	if environment == "" || environment == "*" {
-		dbClient.GetMetricList()
+	var from *model.Report
+	if diffQuery.From == "" {
+		from, err = h.previousReport(client, to)
 	} else {
+		from, err = client.GetReport(diffQuery.From)
+	}
+
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	if from == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(errors.New("no report to compare with")))
+		return
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(diff.Reports(from, to)))
+}
+
+// previousReport returns the report of the same node before the given one
+func (h *ViewHandler) previousReport(client *puppetdb.Client, report *model.Report) (*model.Report, error) {
+	if report.StartTime == nil {
+		return nil, nil
+	}
+
+	reports, _, err := client.GetReports(&puppetdb.PdbQuery{
+		Query: []any{
+			"and",
+			[]any{"=", "certname", report.Certname},
+			[]any{"<", "start_time", report.StartTime.Format(time.RFC3339Nano)},
+		},
+		Limit:   1,
+		OrderBy: []puppetdb.PdbOrderBy{{Field: "start_time", Order: "desc"}},
+	})
+	if err != nil || len(reports) == 0 {
+		return nil, err
+	}
+
+	return &reports[0], nil
+}
+
+type CatalogDiffQuery struct {
+	From string `form:"from" binding:"required"`
+	To   string `form:"to" binding:"required"`
+}
+
+// CatalogDiff compares the latest catalogs of two nodes, e.g. a node in production with
+// its counterpart in staging. PuppetDB keeps only the latest catalog of a node, so the
+// catalogs of one node in different environments or of earlier runs can not be compared.
+func (h *ViewHandler) CatalogDiff(c *gin.Context) {
+	client := pdbClient(c, h.pdbClient)
+
+	var diffQuery CatalogDiffQuery
+	if err := c.BindQuery(&diffQuery); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	var (
+		wg             sync.WaitGroup
+		from, to       *model.Catalog
+		fromErr, toErr error
+	)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		from, fromErr = client.GetCatalog(diffQuery.From)
+	}()
+	go func() {
+		defer wg.Done()
+		to, toErr = client.GetCatalog(diffQuery.To)
+	}()
+	wg.Wait()
+
+	if err := errors.Join(fromErr, toErr); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	if from == nil || to == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(errors.New("catalog does not exists")))
+		return
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(diff.Catalogs(from, to, h.stripPathPrefix)))
+}
+
+type FactDiffQuery struct {
+	Certnames []string `form:"certname"`
+	Facts     []string `form:"fact"`
+}
+
+// FactDiff compares the current facts of two or more nodes, optionally only the given
+// root facts. PuppetDB keeps only the latest factset of a node, so the facts of a node
+// can not be compared with the ones of an earlier run.
+func (h *ViewHandler) FactDiff(c *gin.Context) {
+	var diffQuery FactDiffQuery
+	if err := c.BindQuery(&diffQuery); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	certnames := []string{}
+	for _, certname := range diffQuery.Certnames {
+		if !slices.Contains(certnames, certname) {
+			certnames = append(certnames, certname)
+		}
+	}
 
+	if len(certnames) < 2 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(errors.New("at least two certnames are required")))
+		return
+	}
+
+	certnameQueries := []query.Expr{}
+	for _, certname := range certnames {
+		certnameQueries = append(certnameQueries, query.Equal("certname", certname))
+	}
+
+	nameQueries := []query.Expr{}
+	for _, fact := range diffQuery.Facts {
+		nameQueries = append(nameQueries, query.Equal("name", fact))
+	}
+
+	factsQuery := &puppetdb.PdbQuery{
+		Query: query.ToAST(query.And(query.Or(certnameQueries...), query.Or(nameQueries...))),
 	}
+
+	facts, err := pdbClient(c, h.pdbClient).GetFacts(factsQuery)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(diff.Facts(certnames, facts)))
+}
+
+const (
+	mbeanCommandQueueDepth     = "puppetlabs.puppetdb.mq:name=global.depth"
+	mbeanCommandProcessed      = "puppetlabs.puppetdb.mq:name=global.processed"
+	mbeanCommandProcessingTime = "puppetlabs.puppetdb.mq:name=global.processing-time"
+	mbeanReplaceCatalogTime    = "puppetlabs.puppetdb.storage:name=replace-catalog-time"
+	mbeanReplaceFactsTime      = "puppetlabs.puppetdb.storage:name=replace-facts-time"
+	mbeanStoreReportTime       = "puppetlabs.puppetdb.storage:name=store-report-time"
+	mbeanDloSize               = "puppetlabs.puppetdb.dlo:name=global.filesize"
+	mbeanDloMessages           = "puppetlabs.puppetdb.dlo:name=global.messages"
+	mbeanJvmMemory             = "java.lang:type=Memory"
+)
+
+func (h *ViewHandler) Metrics(c *gin.Context) {
+	summary := model.MetricsSummary{
+		Errors: map[string]string{},
+	}
+
+	// metrics which are not available (e.g. older PuppetDB versions) are reported in Errors,
+	// the endpoint only fails if none of them could be read
+	requested := 0
+	read := func(mbean string) (model.Metric, bool) {
+		requested++
+		metric, err := pdbClient(c, h.pdbClient).GetMetric(mbean)
+		if err != nil {
+			slog.Debug("error reading metric", "mbean", mbean, "error", err)
+			summary.Errors[mbean] = err.Error()
+			return metric, false
+		}
+		return metric, true
+	}
+
+	readTimer := func(mbean string) *model.MetricTimer {
+		metric, ok := read(mbean)
+		if !ok {
+			return nil
+		}
+		return metricTimerFromMetric(metric)
+	}
+
+	if metric, ok := read(mbeanCommandQueueDepth); ok {
+		summary.CommandQueueDepth = metricFloat(metric, "Count")
+	}
+
+	if metric, ok := read(mbeanCommandProcessed); ok {
+		summary.CommandsProcessed = metricFloat(metric, "Count")
+		summary.CommandProcessingRate = metricFloat(metric, "OneMinuteRate")
+	}
+
+	summary.CommandProcessingTime = readTimer(mbeanCommandProcessingTime)
+	summary.StorageReplaceCatalogTime = readTimer(mbeanReplaceCatalogTime)
+	summary.StorageReplaceFactsTime = readTimer(mbeanReplaceFactsTime)
+	summary.StorageStoreReportTime = readTimer(mbeanStoreReportTime)
+
+	if metric, ok := read(mbeanDloSize); ok {
+		summary.DloSizeInBytes = metricFloat(metric, "Value")
+	}
+
+	if metric, ok := read(mbeanDloMessages); ok {
+		summary.DloMessages = metricFloat(metric, "Value")
+	}
+
+	if metric, ok := read(mbeanJvmMemory); ok {
+		if heap, ok := metric.Object("HeapMemoryUsage"); ok {
+			summary.JvmHeap = &model.MetricHeap{}
+			summary.JvmHeap.Used, _ = heap["used"].(float64)
+			summary.JvmHeap.Committed, _ = heap["committed"].(float64)
+			summary.JvmHeap.Max, _ = heap["max"].(float64)
+		}
+	}
+
+	if len(summary.Errors) == requested {
+		c.AbortWithStatusJSON(http.StatusBadGateway, NewErrorResponse(errors.New("no puppetdb metrics available")))
+		return
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(summary))
+}
+
+func metricFloat(metric model.Metric, attribute string) *float64 {
+	value, ok := metric.Float(attribute)
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+func metricTimerFromMetric(metric model.Metric) *model.MetricTimer {
+	timer := model.MetricTimer{}
+	timer.Count, _ = metric.Float("Count")
+	timer.Mean, _ = metric.Float("Mean")
+	timer.P95, _ = metric.Float("95thPercentile")
+	timer.P99, _ = metric.Float("99thPercentile")
+	timer.Max, _ = metric.Float("Max")
+	return &timer
 }
 
 func (h *ViewHandler) PredefinedViews(c *gin.Context) {
@@ -122,12 +684,69 @@ func (h *ViewHandler) PredefinedViews(c *gin.Context) {
 	c.JSON(http.StatusOK, NewSuccessResponse(views))
 }
 
-func (h *ViewHandler) PredefinedViewsResult(c *gin.Context) {
+type PredefinedViewQuery struct {
+	Environment string   `form:"environment"`
+	Certname    string   `form:"certname"`
+	Filter      []string `form:"filter"`
+	Sort        string   `form:"sort"`
+	Order       string   `form:"order" binding:"omitempty,oneof=asc desc"`
+	Offset      int      `form:"offset" binding:"min=0"`
+	Limit       int      `form:"limit" binding:"min=0"`
+
+	filters    []*model.ViewFilter
+	sortColumn string
+}
+
+// parse validates the filters and the sort column against the columns of the view
+func (q *PredefinedViewQuery) parse(view *model.View) error {
+	for _, expression := range q.Filter {
+		filter, err := model.ParseViewFilter(expression)
+		if err != nil {
+			return err
+		}
+		if !view.HasColumn(filter.Column) {
+			return fmt.Errorf("view has no column %q", filter.Column)
+		}
+		q.filters = append(q.filters, filter)
+	}
+
+	q.sortColumn = q.Sort
+	if q.sortColumn == "" {
+		q.sortColumn = model.ViewCertnameColumn
+	}
+	if !view.HasColumn(q.sortColumn) {
+		return fmt.Errorf("view has no column %q", q.sortColumn)
+	}
+
+	return nil
+}
+
+// pagedByPuppetDB is true if PuppetDB applies all filters and sorts the rows, so the
+// view can be fetched page by page
+func (q *PredefinedViewQuery) pagedByPuppetDB(view *model.View) bool {
+	if viewFactField(view, q.sortColumn) == "" {
+		return false
+	}
+
+	return !slices.ContainsFunc(q.filters, func(filter *model.ViewFilter) bool {
+		return viewFilterCondition(view, filter) == nil
+	})
+}
+
+// globRegex converts a certname glob like web*.example.com into an anchored regex
+func globRegex(glob string) string {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+	pattern = strings.ReplaceAll(pattern, `\?`, ".")
+	return "^" + pattern + "$"
+}
+
+func (h *ViewHandler) findView(c *gin.Context) (*model.View, bool) {
 	viewName := c.Param("viewName")
 
 	if viewName == "" {
 		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(errors.New("no view name")))
-		return
+		return nil, false
 	}
 
 	i := slices.IndexFunc(h.config.Views, func(n model.View) bool {
@@ -136,39 +755,178 @@ func (h *ViewHandler) PredefinedViewsResult(c *gin.Context) {
 
 	if i < 0 {
 		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(errors.New("view does not exists")))
-		return
+		return nil, false
 	}
 
-	predefinedView := h.config.Views[i]
-	orQuery := []any{
-		"or",
+	return &h.config.Views[i], true
+}
+
+// predefinedViewRows returns the filtered and sorted page of the view and the number of
+// all matching rows, the query has to be parsed. Environment, certname and the equality
+// and regex filters on plain fact paths are conditions of the PuppetDB inventory query.
+// If no filter is left and the sort column is a plain fact path or the certname,
+// PuppetDB also sorts and pages, otherwise all matching nodes are sorted and paged here.
+func (h *ViewHandler) predefinedViewRows(client *puppetdb.Client, view *model.View, viewQuery *PredefinedViewQuery) ([]map[string]any, int, error) {
+	conditions := []query.Expr{}
+
+	if viewQuery.Environment != "" && viewQuery.Environment != "*" {
+		conditions = append(conditions, query.Equal("environment", viewQuery.Environment))
 	}
 
-	for _, fact := range predefinedView.Facts {
-		root_fact := fact.Fact
+	if viewQuery.Certname != "" {
+		conditions = append(conditions, query.Regex("certname", globRegex(viewQuery.Certname)))
+	}
 
-		if strings.Contains(fact.Fact, ".") {
-			sections := strings.Split(fact.Fact, ".")
-			root_fact = sections[0]
+	filters := []*model.ViewFilter{}
+	for _, filter := range viewQuery.filters {
+		if condition := viewFilterCondition(view, filter); condition != nil {
+			conditions = append(conditions, condition)
+		} else {
+			filters = append(filters, filter)
 		}
+	}
 
-		orQuery = append(orQuery, []any{"=", "name", root_fact})
+	nameQueries := []query.Expr{}
+	for _, fact := range view.Facts {
+		nameQueries = append(nameQueries, query.Equal("name", model.FactRoot(fact.Fact)))
 	}
 
-	factsQuery := puppetdb.PdbQuery{
-		Query: []any{
-			"and",
-			orQuery,
-		},
+	orderField := viewFactField(view, viewQuery.sortColumn)
+	if len(filters) > 0 || orderField == "" {
+		// like the paged rows, the rows are keyed by the matching nodes, so nodes without
+		// any of the view facts are included
+		nodes, _, err := client.GetInventory(&puppetdb.PdbQuery{
+			Query: inventoryCertnameExtract(query.And(conditions...)),
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		factsQuery := query.And(query.Or(nameQueries...), inventoryCertnames(query.And(conditions...)))
+
+		facts, err := client.GetFacts(&puppetdb.PdbQuery{Query: query.ToAST(factsQuery)})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		rows := []map[string]any{}
+		for _, row := range viewRows(view, nodes, facts) {
+			if !slices.ContainsFunc(filters, func(filter *model.ViewFilter) bool { return !filter.Matches(row) }) {
+				rows = append(rows, row)
+			}
+		}
+
+		model.SortViewRows(rows, viewQuery.sortColumn, viewQuery.Order == "desc")
+
+		total := len(rows)
+		rows = rows[min(viewQuery.Offset, total):]
+		if viewQuery.Limit > 0 && viewQuery.Limit < len(rows) {
+			rows = rows[:viewQuery.Limit]
+		}
+
+		return rows, total, nil
+	}
+
+	order := "asc"
+	if viewQuery.Order == "desc" {
+		order = "desc"
 	}
 
-	dbClient := puppetdb.NewClient()
-	facts, err := dbClient.GetFacts(&factsQuery)
+	// rows with the same value are ordered by certname, so pages are stable
+	orderBy := []puppetdb.PdbOrderBy{{Field: orderField, Order: order}}
+	if orderField != model.ViewCertnameColumn {
+		orderBy = append(orderBy, puppetdb.PdbOrderBy{Field: model.ViewCertnameColumn, Order: "asc"})
+	}
+
+	nodes, total, err := client.GetInventory(&puppetdb.PdbQuery{
+		Query:        inventoryCertnameExtract(query.And(conditions...)),
+		Limit:        viewQuery.Limit,
+		Offset:       viewQuery.Offset,
+		OrderBy:      orderBy,
+		IncludeTotal: true,
+	})
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
-		return
+		return nil, 0, err
+	}
+
+	if len(nodes) == 0 {
+		return []map[string]any{}, total, nil
+	}
+
+	certnames := []any{}
+	for _, node := range nodes {
+		certnames = append(certnames, node.Certname)
+	}
+
+	facts, err := client.GetFacts(&puppetdb.PdbQuery{
+		Query: query.ToAST(query.And(query.Or(nameQueries...), query.In("certname", certnames...))),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return viewRows(view, nodes, facts), total, nil
+}
+
+// viewExportPageSize is the number of nodes fetched at once for an export
+const viewExportPageSize = 1000
+
+// exportViewRows returns all rows of the view for an export. If PuppetDB filters and
+// sorts them, they are fetched page by page while the export is written, otherwise
+// they have to be sorted here and are fetched at once. Only the first page can fail
+// the request, later errors end the export early.
+func (h *ViewHandler) exportViewRows(client *puppetdb.Client, view *model.View, viewQuery *PredefinedViewQuery) (iter.Seq[map[string]any], error) {
+	if !viewQuery.pagedByPuppetDB(view) {
+		rows, _, err := h.predefinedViewRows(client, view, viewQuery)
+		if err != nil {
+			return nil, err
+		}
+		return slices.Values(rows), nil
 	}
 
+	limit := viewQuery.Limit
+	page := *viewQuery
+	page.Limit = viewExportPageSize
+	if limit > 0 {
+		page.Limit = min(limit, viewExportPageSize)
+	}
+
+	rows, _, err := h.predefinedViewRows(client, view, &page)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(yield func(map[string]any) bool) {
+		exported := 0
+		for {
+			for _, row := range rows {
+				if !yield(row) {
+					return
+				}
+			}
+
+			exported += len(rows)
+			if len(rows) < page.Limit || (limit > 0 && exported >= limit) {
+				return
+			}
+
+			page.Offset += len(rows)
+			if limit > 0 {
+				page.Limit = min(limit-exported, viewExportPageSize)
+			}
+
+			rows, _, err = h.predefinedViewRows(client, view, &page)
+			if err != nil {
+				slog.Error("error fetching the next page of the view export", "view", view.Name, "offset", page.Offset, "error", err)
+				return
+			}
+		}
+	}, nil
+}
+
+// viewRows returns a row with the certname and the resolved value of each view fact
+// keyed by its name per node in the order of the nodes. Facts of other nodes are ignored.
+func viewRows(view *model.View, nodes []model.Inventory, facts []model.Fact) []map[string]any {
 	mapped := map[string]map[string]any{}
 	for _, fact := range facts {
 		if _, exists := mapped[fact.Certname]; !exists {
@@ -178,36 +936,158 @@ func (h *ViewHandler) PredefinedViewsResult(c *gin.Context) {
 		mapped[fact.Certname][fact.Name] = fact.Value
 	}
 
-	flattend := []map[string]any{}
-	for _, value := range mapped {
-		flattend = append(flattend, value)
-	}
+	rows := make([]map[string]any, 0, len(nodes))
+	for _, node := range nodes {
+		row := make(map[string]any, len(view.Facts)+1)
+		for _, fact := range view.Facts {
+			row[fact.Name] = model.ResolveFactPath(mapped[node.Certname], fact.Fact)
+		}
+		row[model.ViewCertnameColumn] = node.Certname
 
-	result := model.ViewResult{
-		View: predefinedView,
-		Data: flattend,
+		rows = append(rows, row)
 	}
 
-	c.JSON(http.StatusOK, NewSuccessResponse(result))
+	return rows
 }
 
-func (h *ViewHandler) PredefinedViewsMeta(c *gin.Context) {
-	viewName := c.Param("viewName")
+// inventoryCertnameExtract returns the inventory query of the certnames of the nodes
+// matching the condition, all nodes without condition
+func inventoryCertnameExtract(condition query.Expr) []any {
+	extract := []any{"extract", []any{model.ViewCertnameColumn}}
+	if condition != nil {
+		extract = append(extract, condition.AST())
+	}
+	return extract
+}
 
-	if viewName == "" {
-		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(errors.New("no view name")))
-		return
+// inventoryCertnames restricts a query to the nodes matching the inventory condition,
+// nil without condition
+func inventoryCertnames(condition query.Expr) query.Expr {
+	if condition == nil {
+		return nil
 	}
 
-	i := slices.IndexFunc(h.config.Views, func(n model.View) bool {
-		return n.Name == viewName
+	return query.InQuery(model.ViewCertnameColumn, &query.Query{
+		Entity: "inventory",
+		Fields: []string{model.ViewCertnameColumn},
+		Where:  condition,
 	})
+}
+
+// viewFactField returns the inventory field of a view column, e.g. facts.os.family, or
+// nothing if the fact path has wildcards or array indexes, which PuppetDB can not match
+// like ResolveFactPath
+func viewFactField(view *model.View, column string) string {
+	if column == model.ViewCertnameColumn {
+		return model.ViewCertnameColumn
+	}
 
+	i := slices.IndexFunc(view.Facts, func(fact model.ViewFact) bool {
+		return fact.Name == column
+	})
 	if i < 0 {
-		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(errors.New("view does not exists")))
+		return ""
+	}
+
+	for _, segment := range strings.Split(view.Facts[i].Fact, ".") {
+		if _, err := strconv.Atoi(segment); err == nil || segment == "*" || segment == "" {
+			return ""
+		}
+	}
+
+	return "facts." + view.Facts[i].Fact
+}
+
+// viewFilterCondition returns the PuppetDB condition of an equality or regex filter on
+// the certname or a plain fact path, nil if the filter has to be applied to the rows.
+// Values that look like numbers or booleans also match facts of that type.
+func viewFilterCondition(view *model.View, filter *model.ViewFilter) query.Expr {
+	field := viewFactField(view, filter.Column)
+	if field == "" {
+		return nil
+	}
+
+	switch filter.Operator {
+	case "~":
+		return query.Regex(field, filter.Value)
+	case "=":
+		conditions := []query.Expr{query.Equal(field, filter.Value)}
+		if field == model.ViewCertnameColumn {
+			return conditions[0]
+		}
+
+		if number, err := strconv.ParseFloat(filter.Value, 64); err == nil {
+			conditions = append(conditions, query.Equal(field, number))
+		}
+		if filter.Value == "true" || filter.Value == "false" {
+			conditions = append(conditions, query.Equal(field, filter.Value == "true"))
+		}
+
+		return query.Or(conditions...)
+	}
+
+	return nil
+}
+
+func (h *ViewHandler) PredefinedViewsResult(c *gin.Context) {
+	predefinedView, found := h.findView(c)
+	if !found {
+		return
+	}
+
+	var viewQuery PredefinedViewQuery
+	if err := c.BindQuery(&viewQuery); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
+	if err := viewQuery.parse(predefinedView); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	if format != "" {
+		rows, err := h.exportViewRows(pdbClient(c, h.pdbClient), predefinedView, &viewQuery)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+			return
+		}
+
+		writeExport(c, format, predefinedView.Name, predefinedView.Columns(), func(yield func([]any) bool) {
+			for row := range rows {
+				if !yield(predefinedView.RowValues(row)) {
+					return
+				}
+			}
+		})
+		return
+	}
+
+	rows, total, err := h.predefinedViewRows(pdbClient(c, h.pdbClient), predefinedView, &viewQuery)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	result := model.ViewResult{
+		View:  *predefinedView,
+		Data:  rows,
+		Total: total,
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(result))
+}
+
+func (h *ViewHandler) PredefinedViewsMeta(c *gin.Context) {
+	predefinedView, found := h.findView(c)
+	if !found {
 		return
 	}
 
-	predefinedView := h.config.Views[i]
 	c.JSON(http.StatusOK, NewSuccessResponse(predefinedView))
 }
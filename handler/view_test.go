@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/model"
+	"github.com/sebastianrakel/openvoxview/puppetdb"
+)
+
+// viewTestNodes are the inventory of the fake PuppetDB, db1 has none of the view facts
+var viewTestNodes = []model.Inventory{
+	{Certname: "web1", Environment: "production", Facts: map[string]any{"os": map[string]any{"family": "RedHat"}, "role": "web"}},
+	{Certname: "web2", Environment: "production", Facts: map[string]any{"os": map[string]any{"family": "Debian"}}},
+	{Certname: "db1", Environment: "production", Facts: map[string]any{"uptime": "1 day"}},
+	{Certname: "test1", Environment: "testing", Facts: map[string]any{"os": map[string]any{"family": "RedHat"}, "role": "db"}},
+}
+
+// fakePuppetDB answers inventory and facts queries of viewTestNodes with the subset of
+// the AST query language the views use
+func fakePuppetDB(t *testing.T) *puppetdb.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pdbQuery puppetdb.PdbQuery
+		if err := json.NewDecoder(r.Body).Decode(&pdbQuery); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var result []any
+		switch r.URL.Path {
+		case "/pdb/query/v4/inventory":
+			if pdbQuery.Query[0] != "extract" {
+				t.Errorf("inventory query %v is no extract", pdbQuery.Query)
+			}
+
+			var condition []any
+			if len(pdbQuery.Query) > 2 {
+				var isQuery bool
+				if condition, isQuery = pdbQuery.Query[2].([]any); !isQuery {
+					t.Errorf("inventory query %v has no valid condition", pdbQuery.Query)
+				}
+			}
+
+			nodes := []model.Inventory{}
+			for _, node := range viewTestNodes {
+				if condition == nil || fakeMatches(t, inventoryRecord(node), condition) {
+					nodes = append(nodes, node)
+				}
+			}
+
+			slices.SortStableFunc(nodes, func(a, b model.Inventory) int {
+				for _, order := range pdbQuery.OrderBy {
+					compared := cmp.Compare(fmt.Sprint(inventoryRecord(a)[order.Field]), fmt.Sprint(inventoryRecord(b)[order.Field]))
+					if order.Order == "desc" {
+						compared = -compared
+					}
+					if compared != 0 {
+						return compared
+					}
+				}
+				return 0
+			})
+
+			if pdbQuery.IncludeTotal {
+				w.Header().Set("X-Records", strconv.Itoa(len(nodes)))
+			}
+
+			nodes = nodes[min(pdbQuery.Offset, len(nodes)):]
+			if pdbQuery.Limit > 0 && pdbQuery.Limit < len(nodes) {
+				nodes = nodes[:pdbQuery.Limit]
+			}
+			for _, node := range nodes {
+				result = append(result, map[string]any{"certname": node.Certname})
+			}
+		case "/pdb/query/v4/facts":
+			for _, node := range viewTestNodes {
+				for name, value := range node.Facts {
+					fact := map[string]any{"certname": node.Certname, "environment": node.Environment, "name": name, "value": value}
+					if fakeMatches(t, fact, pdbQuery.Query) {
+						result = append(result, fact)
+					}
+				}
+			}
+		default:
+			t.Errorf("unexpected request of %s", r.URL.Path)
+		}
+
+		json.NewEncoder(w).Encode(result)
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.PuppetDB.Host = host
+	cfg.PuppetDB.Port, _ = strconv.ParseUint(port, 10, 64)
+	return puppetdb.NewClient(cfg)
+}
+
+// inventoryRecord returns the fields of a node with its facts as facts.<path>
+func inventoryRecord(node model.Inventory) map[string]any {
+	record := map[string]any{"certname": node.Certname, "environment": node.Environment}
+	for name, value := range node.Facts {
+		for path, leaf := range model.FactLeaves(name, value) {
+			record["facts."+path] = leaf
+		}
+	}
+	return record
+}
+
+func fakeMatches(t *testing.T, record map[string]any, ast []any) bool {
+	switch ast[0] {
+	case "and":
+		for _, expr := range ast[1:] {
+			if !fakeMatches(t, record, expr.([]any)) {
+				return false
+			}
+		}
+		return true
+	case "or":
+		for _, expr := range ast[1:] {
+			if fakeMatches(t, record, expr.([]any)) {
+				return true
+			}
+		}
+		return false
+	case "=":
+		return record[ast[1].(string)] == ast[2]
+	case "~":
+		value, isString := record[ast[1].(string)].(string)
+		return isString && regexp.MustCompile(ast[2].(string)).MatchString(value)
+	case "in":
+		values := ast[2].([]any)
+		switch values[0] {
+		case "array":
+			return slices.Contains(values[1].([]any), record[ast[1].(string)])
+		case "from":
+			for _, node := range viewTestNodes {
+				extract := values[2].([]any)
+				if node.Certname == record[ast[1].(string)] && (len(extract) < 3 || fakeMatches(t, inventoryRecord(node), extract[2].([]any))) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	t.Errorf("unsupported query %v", ast)
+	return false
+}
+
+func TestPredefinedViewRowsPaths(t *testing.T) {
+	view := &model.View{
+		Name: "os",
+		Facts: []model.ViewFact{
+			{Name: "OS", Fact: "os.family"},
+			{Name: "Role", Fact: "role"},
+		},
+	}
+
+	h := &ViewHandler{config: &config.Config{}}
+	client := fakePuppetDB(t)
+
+	tests := []struct {
+		name      string
+		query     PredefinedViewQuery
+		certnames []string
+	}{
+		{"all nodes", PredefinedViewQuery{}, []string{"db1", "test1", "web1", "web2"}},
+		{"environment", PredefinedViewQuery{Environment: "production"}, []string{"db1", "web1", "web2"}},
+		{"certname glob", PredefinedViewQuery{Certname: "web*"}, []string{"web1", "web2"}},
+		{"fact filter", PredefinedViewQuery{Filter: []string{"OS=RedHat"}}, []string{"test1", "web1"}},
+		{"descending", PredefinedViewQuery{Order: "desc"}, []string{"web2", "web1", "test1", "db1"}},
+		{"page", PredefinedViewQuery{Offset: 1, Limit: 2}, []string{"test1", "web1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paged := test.query
+			if err := paged.parse(view); err != nil {
+				t.Fatal(err)
+			}
+			if !paged.pagedByPuppetDB(view) {
+				t.Fatalf("query %+v is not paged by PuppetDB", test.query)
+			}
+
+			// a filter that matches every row is applied here, so all rows are fetched
+			fetched := test.query
+			fetched.Filter = append(slices.Clone(fetched.Filter), "certname!=none")
+			if err := fetched.parse(view); err != nil {
+				t.Fatal(err)
+			}
+			if fetched.pagedByPuppetDB(view) {
+				t.Fatalf("query %+v is paged by PuppetDB", fetched)
+			}
+
+			pagedRows, pagedTotal, err := h.predefinedViewRows(client, view, &paged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fetchedRows, fetchedTotal, err := h.predefinedViewRows(client, view, &fetched)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			certnames := []string{}
+			for _, row := range pagedRows {
+				certnames = append(certnames, row[model.ViewCertnameColumn].(string))
+			}
+			if !slices.Equal(certnames, test.certnames) {
+				t.Errorf("certnames = %v, want %v", certnames, test.certnames)
+			}
+
+			if !reflect.DeepEqual(fetchedRows, pagedRows) {
+				t.Errorf("rows fetched at once = %v, rows paged by PuppetDB = %v", fetchedRows, pagedRows)
+			}
+			if fetchedTotal != pagedTotal {
+				t.Errorf("total fetched at once = %d, paged by PuppetDB = %d", fetchedTotal, pagedTotal)
+			}
+		})
+	}
+}
+
+func TestNewViewHandlerReservedCertname(t *testing.T) {
+	cfg := &config.Config{Views: []model.View{{
+		Name:  "certs",
+		Facts: []model.ViewFact{{Name: model.ViewCertnameColumn, Fact: "trusted.certname"}},
+	}}}
+
+	if _, err := NewViewHandler(cfg, nil, nil); err == nil {
+		t.Error("view with a fact named certname is accepted")
+	}
+}
@@ -0,0 +1,205 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/sebastianrakel/openvoxview/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// fileStore keeps the history in a bbolt database, with one bucket per user and the
+// entries keyed by their big endian id, so a cursor walks them in insertion order
+type fileStore struct {
+	db         *bolt.DB
+	maxEntries int
+	maxPinned  int
+}
+
+func NewFileStore(path string, maxEntries int, maxPinned int) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileStore{
+		db:         db,
+		maxEntries: maxEntries,
+		maxPinned:  maxPinned,
+	}, nil
+}
+
+func itob(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
+
+func userBucketName(user string) []byte {
+	// bbolt does not allow empty bucket names, the anonymous user gets its own prefix
+	return []byte("user:" + user)
+}
+
+// readAll returns all entries of the bucket newest first
+func readAll(bucket *bolt.Bucket) ([]model.QueryHistoryEntry, error) {
+	entries := []model.QueryHistoryEntry{}
+	if bucket == nil {
+		return entries, nil
+	}
+
+	c := bucket.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		var entry model.QueryHistoryEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (s *fileStore) Add(entry model.QueryHistoryEntry) (model.QueryHistoryEntry, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(userBucketName(entry.User))
+		if err != nil {
+			return err
+		}
+
+		entry.Id, err = bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		if err := bucket.Put(itob(entry.Id), data); err != nil {
+			return err
+		}
+
+		entries, err := readAll(bucket)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range overflow(entries, s.maxEntries) {
+			if err := bucket.Delete(itob(id)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return entry, err
+}
+
+func (s *fileStore) List(user string, query ListQuery) ([]model.QueryHistoryEntry, int, error) {
+	var entries []model.QueryHistoryEntry
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		entries, err = readAll(tx.Bucket(userBucketName(user)))
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if query.PinnedOnly {
+		pinned := []model.QueryHistoryEntry{}
+		for _, entry := range entries {
+			if entry.Pinned {
+				pinned = append(pinned, entry)
+			}
+		}
+		entries = pinned
+	}
+
+	return page(entries, query), len(entries), nil
+}
+
+func (s *fileStore) Delete(user string, id uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(userBucketName(user))
+		if bucket == nil || bucket.Get(itob(id)) == nil {
+			return ErrNotFound
+		}
+
+		return bucket.Delete(itob(id))
+	})
+}
+
+// Clear deletes the entries but keeps the bucket, so its sequence is not reset and the
+// ids of cleared entries are never given to new ones
+func (s *fileStore) Clear(user string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(userBucketName(user))
+		if bucket == nil {
+			return nil
+		}
+
+		keys := [][]byte{}
+		err := bucket.ForEach(func(key, _ []byte) error {
+			keys = append(keys, slices.Clone(key))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *fileStore) SetPinned(user string, id uint64, pinned bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(userBucketName(user))
+		if bucket == nil {
+			return ErrNotFound
+		}
+
+		data := bucket.Get(itob(id))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		var entry model.QueryHistoryEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+
+		if pinned && !entry.Pinned {
+			entries, err := readAll(bucket)
+			if err != nil {
+				return err
+			}
+			if pinnedLimitReached(entries, s.maxPinned) {
+				return ErrPinnedLimit
+			}
+		}
+
+		entry.Pinned = pinned
+
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(itob(id), data)
+	})
+}
+
+func (s *fileStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,98 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+const (
+	BACKEND_MEMORY = "memory"
+	BACKEND_FILE   = "file"
+)
+
+var (
+	ErrNotFound    = errors.New("history entry not found")
+	ErrPinnedLimit = errors.New("maximum of pinned history entries reached")
+)
+
+type ListQuery struct {
+	Offset     int
+	Limit      int
+	PinnedOnly bool
+}
+
+// Store keeps the executed PQL queries per user. Every store is bounded by the configured
+// maximum entries per user, the oldest entries which are not pinned are dropped first.
+// Pinning fails with ErrPinnedLimit once the user has the maximum pinned entries.
+type Store interface {
+	Add(entry model.QueryHistoryEntry) (model.QueryHistoryEntry, error)
+	// List returns the entries of the user, newest first, and the total count of entries
+	List(user string, query ListQuery) ([]model.QueryHistoryEntry, int, error)
+	Delete(user string, id uint64) error
+	Clear(user string) error
+	SetPinned(user string, id uint64, pinned bool) error
+	Close() error
+}
+
+func NewStore(cfg *config.Config) (Store, error) {
+	switch cfg.QueryHistory.Backend {
+	case BACKEND_MEMORY, "":
+		return NewMemoryStore(cfg.QueryHistory.MaxEntries, cfg.QueryHistory.MaxPinned), nil
+	case BACKEND_FILE:
+		return NewFileStore(cfg.QueryHistory.Path, cfg.QueryHistory.MaxEntries, cfg.QueryHistory.MaxPinned)
+	default:
+		return nil, fmt.Errorf("unknown query history backend %q", cfg.QueryHistory.Backend)
+	}
+}
+
+// page returns the requested page of the entries, which are expected to be sorted newest first
+func page(entries []model.QueryHistoryEntry, query ListQuery) []model.QueryHistoryEntry {
+	if query.Offset >= len(entries) {
+		return []model.QueryHistoryEntry{}
+	}
+
+	entries = entries[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(entries) {
+		entries = entries[:query.Limit]
+	}
+
+	return entries
+}
+
+// overflow returns the ids of the oldest unpinned entries exceeding maxEntries, entries
+// are expected to be sorted newest first
+func overflow(entries []model.QueryHistoryEntry, maxEntries int) []uint64 {
+	if maxEntries <= 0 || len(entries) <= maxEntries {
+		return nil
+	}
+
+	ids := []uint64{}
+	excess := len(entries) - maxEntries
+	for i := len(entries) - 1; i >= 0 && len(ids) < excess; i-- {
+		if !entries[i].Pinned {
+			ids = append(ids, entries[i].Id)
+		}
+	}
+
+	return ids
+}
+
+// pinnedLimitReached reports whether another entry can not be pinned, because the
+// entries already contain maxPinned pinned entries
+func pinnedLimitReached(entries []model.QueryHistoryEntry, maxPinned int) bool {
+	if maxPinned <= 0 {
+		return false
+	}
+
+	pinned := 0
+	for _, entry := range entries {
+		if entry.Pinned {
+			pinned++
+		}
+	}
+
+	return pinned >= maxPinned
+}
@@ -0,0 +1,105 @@
+package history
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+type memoryStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxPinned  int
+	lastId     uint64
+	// entries per user, newest first
+	entries map[string][]model.QueryHistoryEntry
+}
+
+func NewMemoryStore(maxEntries int, maxPinned int) Store {
+	return &memoryStore{
+		maxEntries: maxEntries,
+		maxPinned:  maxPinned,
+		entries:    map[string][]model.QueryHistoryEntry{},
+	}
+}
+
+func (s *memoryStore) Add(entry model.QueryHistoryEntry) (model.QueryHistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastId++
+	entry.Id = s.lastId
+
+	entries := append([]model.QueryHistoryEntry{entry}, s.entries[entry.User]...)
+	for _, id := range overflow(entries, s.maxEntries) {
+		entries = slices.DeleteFunc(entries, func(e model.QueryHistoryEntry) bool {
+			return e.Id == id
+		})
+	}
+	s.entries[entry.User] = entries
+
+	return entry, nil
+}
+
+func (s *memoryStore) List(user string, query ListQuery) ([]model.QueryHistoryEntry, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := slices.Clone(s.entries[user])
+	if query.PinnedOnly {
+		entries = slices.DeleteFunc(entries, func(e model.QueryHistoryEntry) bool {
+			return !e.Pinned
+		})
+	}
+
+	return page(entries, query), len(entries), nil
+}
+
+func (s *memoryStore) Delete(user string, id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.entries[user]
+	i := slices.IndexFunc(entries, func(e model.QueryHistoryEntry) bool {
+		return e.Id == id
+	})
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	s.entries[user] = slices.Delete(entries, i, i+1)
+	return nil
+}
+
+func (s *memoryStore) Clear(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, user)
+	return nil
+}
+
+func (s *memoryStore) SetPinned(user string, id uint64, pinned bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.entries[user]
+	i := slices.IndexFunc(entries, func(e model.QueryHistoryEntry) bool {
+		return e.Id == id
+	})
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	if pinned && !entries[i].Pinned && pinnedLimitReached(entries, s.maxPinned) {
+		return ErrPinnedLimit
+	}
+
+	entries[i].Pinned = pinned
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -1,16 +1,28 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sebastianrakel/openvoxview/alerting"
+	"github.com/sebastianrakel/openvoxview/audit"
+	"github.com/sebastianrakel/openvoxview/auth"
+	"github.com/sebastianrakel/openvoxview/autosign"
 	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/events"
 	"github.com/sebastianrakel/openvoxview/handler"
+	"github.com/sebastianrakel/openvoxview/history"
+	"github.com/sebastianrakel/openvoxview/metrics"
+	"github.com/sebastianrakel/openvoxview/model"
+	"github.com/sebastianrakel/openvoxview/puppetca"
+	"github.com/sebastianrakel/openvoxview/puppetdb"
 )
 
 var (
@@ -35,9 +47,13 @@ func main() {
 	slog.Info(fmt.Sprintf("PORT: %d", cfg.Port))
 	slog.Info(fmt.Sprintf("PUPPETDB_ADDRESS: %s", cfg.GetPuppetDbAddress()))
 	slog.Info(fmt.Sprintf("TRUSTED_PROXIES: %s", cfg.TrustedProxies))
+	slog.Info(fmt.Sprintf("AUTH_ENABLED: %t", cfg.Auth.Enabled()))
 
 	r := gin.New()
 	r.Use(SlogMiddleware(logger))
+	if cfg.Metrics.Enabled {
+		r.Use(metrics.Middleware())
+	}
 
 	r.NoRoute(func(c *gin.Context) {
 		if strings.HasPrefix(c.Request.URL.Path, "/api/") {
@@ -48,6 +64,17 @@ func main() {
 		c.Redirect(http.StatusTemporaryRedirect, "/ui/?#/")
 	})
 
+	authenticator, err := auth.NewAuthenticator(cfg)
+	if err != nil {
+		panic(err)
+	}
+	authHandler := handler.NewAuthHandler(authenticator)
+	publicPaths := []string{"/api/v1/auth/", "/api/v1/me", "/api/v1/version"}
+	if cfg.Metrics.Public {
+		publicPaths = append(publicPaths, "/metrics")
+	}
+	r.Use(authHandler.RequireAuth(publicPaths...))
+
 	uiFSSub, _ := fs.Sub(uiFS, "ui/dist/spa")
 	r.StaticFS("ui", http.FS(uiFSSub))
 	r.Use(AllowCORS)
@@ -58,8 +85,64 @@ func main() {
 
 	caEnabled := cfg.PuppetCA.Host != ""
 
-	pdbHandler := handler.NewPdbHandler(cfg)
-	viewHandler := handler.NewViewHandler(cfg)
+	pdbClient := puppetdb.NewClient(cfg)
+	defer pdbClient.Close()
+
+	historyStore, err := history.NewStore(cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer historyStore.Close()
+
+	// only the CA endpoints and autosign record audit events
+	var auditLogger *audit.Logger
+	if caEnabled {
+		auditLogger, err = audit.NewLogger(cfg)
+		if err != nil {
+			panic(err)
+		}
+		defer auditLogger.Close()
+	}
+
+	if cfg.Metrics.Enabled {
+		metrics.RegisterFleet(func() ([]model.Node, error) {
+			return pdbClient.GetNodes(&puppetdb.PdbQuery{})
+		}, cfg.UnreportedHours)
+
+		if cfg.Cache.Enabled {
+			metrics.RegisterCacheEntries(pdbClient.CacheEntries)
+		}
+	}
+
+	pdbHandler := handler.NewPdbHandler(cfg, pdbClient, historyStore)
+	var caClient *puppetca.Client
+	if caEnabled {
+		caClient = puppetca.NewClient(cfg)
+	}
+
+	viewHandler, err := handler.NewViewHandler(cfg, pdbClient, caClient)
+	if err != nil {
+		panic(err)
+	}
+
+	var watcher *events.Watcher
+	if cfg.Events.Enabled {
+		watcher, err = events.NewWatcher(pdbClient, caClient, cfg.Events.Interval, cfg.UnreportedHours)
+		if err != nil {
+			panic(err)
+		}
+		go watcher.Run(context.Background())
+	}
+
+	if cfg.Alerting.Enabled {
+		alertRunner, err := alerting.NewRunner(cfg, pdbClient, caClient)
+		if err != nil {
+			panic(err)
+		}
+		defer alertRunner.Close()
+
+		go alertRunner.Run(context.Background())
+	}
 
 	api := r.Group("/api/v1/")
 	{
@@ -67,21 +150,40 @@ func main() {
 			type metaResponse struct {
 				CaEnabled                         bool
 				CaReadOnly                        bool
+				Permissions                       []auth.Permission
 				UnreportedHours                   uint64
 				StripPathPrefix                   string
 				UiDefaultRefreshIntervalInSeconds uint
+				EventsEnabled                     bool
+			}
+
+			permissions := authHandler.Permissions(c)
+			if cfg.PuppetCA.ReadOnly {
+				permissions = slices.DeleteFunc(permissions, func(p auth.Permission) bool {
+					return p == auth.PERMISSION_CA_WRITE
+				})
 			}
 
 			response := metaResponse{
 				CaEnabled:                         caEnabled,
-				CaReadOnly:                        cfg.PuppetCA.ReadOnly,
+				CaReadOnly:                        !slices.Contains(permissions, auth.PERMISSION_CA_WRITE),
+				Permissions:                       permissions,
 				UnreportedHours:                   cfg.UnreportedHours,
 				StripPathPrefix:                   cfg.StripPathPrefix,
 				UiDefaultRefreshIntervalInSeconds: cfg.UiDefaultRefreshIntervalInSeconds,
+				EventsEnabled:                     watcher != nil,
 			}
 
 			c.JSON(http.StatusOK, handler.NewSuccessResponse(response))
 		})
+		api.GET("me", authHandler.Me)
+		authGroup := api.Group("auth")
+		{
+			authGroup.POST("login", authHandler.Login)
+			authGroup.POST("logout", authHandler.Logout)
+			authGroup.GET("oidc/login", authHandler.OIDCLogin)
+			authGroup.GET("oidc/callback", authHandler.OIDCCallback)
+		}
 		api.GET("version", func(c *gin.Context) {
 			type versionResponse struct {
 				Version string
@@ -93,9 +195,25 @@ func main() {
 
 			c.JSON(http.StatusOK, handler.NewSuccessResponse(response))
 		})
-		view := api.Group("view")
+		if auditLogger != nil {
+			auditHandler := handler.NewAuditHandler(auditLogger)
+			api.GET("audit", authHandler.RequirePermission(auth.PERMISSION_AUDIT), auditHandler.QueryAuditEvents)
+		}
+
+		if watcher != nil {
+			eventHandler := handler.NewEventHandler(watcher)
+			api.GET("events/stream", authHandler.RequirePermission(auth.PERMISSION_VIEW), eventHandler.Stream)
+		}
+
+		view := api.Group("view", authHandler.RequirePermission(auth.PERMISSION_VIEW))
 		{
 			view.GET("node_overview", viewHandler.NodesOverview)
+			view.GET("node/:certname", viewHandler.NodeDetail)
+			view.GET("reports", viewHandler.Reports)
+			view.GET("reports/diff", viewHandler.ReportDiff)
+			view.GET("reports/:hash", viewHandler.Report)
+			view.GET("catalogs/diff", viewHandler.CatalogDiff)
+			view.GET("facts/diff", viewHandler.FactDiff)
 			view.GET("metrics", viewHandler.Metrics)
 			view.GET("predefined", viewHandler.PredefinedViews)
 			view.GET("predefined/:viewName", viewHandler.PredefinedViewsResult)
@@ -104,23 +222,67 @@ func main() {
 
 		pdb := api.Group("pdb")
 		{
-			pdb.POST("query", pdbHandler.PdbExecuteQuery)
-			pdb.GET("query/history", pdbHandler.PdbQueryHistory)
-			pdb.GET("query/predefined", pdbHandler.PdbQueryPredefined)
-			pdb.GET("fact-names", pdbHandler.PdbGetFactNames)
-			pdb.POST("event-counts", pdbHandler.PdbGetEventCounts)
+			requireQuery := authHandler.RequirePermission(auth.PERMISSION_QUERY)
+			requireView := authHandler.RequirePermission(auth.PERMISSION_VIEW)
+
+			pdb.POST("query", requireQuery, pdbHandler.PdbExecuteQuery)
+			pdb.POST("query/validate", requireQuery, pdbHandler.PdbValidateQuery)
+			pdb.GET("query/history", requireQuery, pdbHandler.PdbQueryHistory)
+			pdb.DELETE("query/history", requireQuery, pdbHandler.PdbQueryHistoryClear)
+			pdb.DELETE("query/history/:id", requireQuery, pdbHandler.PdbQueryHistoryDelete)
+			pdb.PUT("query/history/:id/pin", requireQuery, pdbHandler.PdbQueryHistoryPin)
+			pdb.DELETE("query/history/:id/pin", requireQuery, pdbHandler.PdbQueryHistoryUnpin)
+			pdb.GET("query/predefined", requireQuery, pdbHandler.PdbQueryPredefined)
+			pdb.GET("fact-names", requireView, pdbHandler.PdbGetFactNames)
+			pdb.POST("event-counts", requireView, pdbHandler.PdbGetEventCounts)
 		}
 	}
 
 	if caEnabled {
-		caHandler := handler.NewCaHandler(cfg)
-		ca := api.Group("ca")
+		evaluator, err := autosign.NewEvaluator(cfg.PuppetCA.Autosign.Policies)
+		if err != nil {
+			panic(err)
+		}
+		autosignRunner, err := autosign.NewRunner(caClient, evaluator, auditLogger, cfg.PuppetCA.Autosign.Interval)
+		if err != nil {
+			panic(err)
+		}
+
+		if cfg.PuppetCA.Autosign.Enabled {
+			if cfg.PuppetCA.ReadOnly {
+				slog.Warn("autosign is enabled, but puppetca.readonly is true, not signing anything")
+			} else {
+				go autosignRunner.Run(context.Background())
+			}
+		}
+
+		if cfg.Metrics.Enabled {
+			metrics.RegisterCertificateExpiry(caClient, cfg.Metrics.CertificateExpiries, cfg.PuppetCA.ExpiryDays)
+		}
+
+		caHandler := handler.NewCaHandler(cfg, caClient, pdbClient, auditLogger, autosignRunner)
+		ca := api.Group("ca", authHandler.RequirePermission(auth.PERMISSION_VIEW))
 
 		ca.POST("status", caHandler.QueryCertificateStatuses)
+		ca.GET("expiry", caHandler.ExpiringCertificates)
+		ca.GET("autosign/dry-run", caHandler.AutosignDryRun)
 		if !cfg.PuppetCA.ReadOnly {
-			ca.POST("status/:name/sign", caHandler.SignCertificate)
-			ca.POST("status/:name/revoke", caHandler.RevokeCertificate)
-			ca.DELETE("status/:name", caHandler.CleanCertificate)
+			requireCaWrite := authHandler.RequirePermission(auth.PERMISSION_CA_WRITE)
+
+			ca.POST("status/:name/sign", requireCaWrite, caHandler.SignCertificate)
+			ca.POST("status/:name/revoke", requireCaWrite, caHandler.RevokeCertificate)
+			ca.DELETE("status/:name", requireCaWrite, caHandler.CleanCertificate)
+			ca.POST("bulk/sign", requireCaWrite, caHandler.BulkSignCertificates)
+			ca.POST("bulk/revoke", requireCaWrite, caHandler.BulkRevokeCertificates)
+			ca.POST("bulk/clean", requireCaWrite, caHandler.BulkCleanCertificates)
+		}
+	}
+
+	if cfg.Metrics.Enabled {
+		if cfg.Metrics.Public {
+			r.GET("metrics", metrics.Handler())
+		} else {
+			r.GET("metrics", authHandler.RequirePermission(auth.PERMISSION_VIEW), metrics.Handler())
 		}
 	}
 
@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	ALERT_SENT      = "sent"
+	ALERT_FAILED    = "failed"
+	ALERT_THROTTLED = "throttled"
+	ALERT_BATCHED   = "batched"
+)
+
+var alertNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "alerting",
+	Name:      "notifications_total",
+	Help:      "Number of alert notifications by rule, channel and result (sent, failed, throttled, batched)",
+}, []string{"rule", "channel", "result"})
+
+// ObserveAlert records a notification, throttled notifications are sent later if the
+// condition still holds, batched ones were part of a summary notification
+func ObserveAlert(rule string, channel string, result string) {
+	alertNotifications.WithLabelValues(rule, channel, result).Inc()
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	CACHE_HIT       = "hit"
+	CACHE_MISS      = "miss"
+	CACHE_COALESCED = "coalesced"
+	CACHE_BYPASS    = "bypass"
+)
+
+var cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "cache",
+	Name:      "requests_total",
+	Help:      "Number of cacheable PuppetDB requests by result (hit, miss, coalesced, bypass)",
+}, []string{"endpoint", "result"})
+
+// ObserveCache records a lookup in the PuppetDB result cache, coalesced requests waited
+// for an identical request that was already running
+func ObserveCache(endpoint string, result string) {
+	cacheRequests.WithLabelValues(endpoint, result).Inc()
+}
+
+// RegisterCacheEntries exports the number of entries in the PuppetDB result cache
+func RegisterCacheEntries(entries func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "entries",
+		Help:      "Number of responses in the PuppetDB result cache",
+	}, func() float64 {
+		return float64(entries())
+	})
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+// certificateCacheTTL prevents that every scrape queries the puppet ca
+const certificateCacheTTL = 5 * time.Minute
+
+// CertificateSource is implemented by the puppetca client
+type CertificateSource interface {
+	GetCertificates(state *model.CertificateState) ([]model.CertificateStatus, error)
+}
+
+type certificateCollector struct {
+	caClient   CertificateSource
+	count      int
+	windowDays int
+
+	mu        sync.Mutex
+	fetchedAt time.Time
+	certs     []model.ExpiringCertificate
+	fetchErr  error
+
+	notAfter    *prometheus.Desc
+	expiring    *prometheus.Desc
+	scrapeError *prometheus.Desc
+}
+
+// RegisterCertificateExpiry exports the expiry of the count soonest expiring signed
+// certificates and the number of certificates expiring within windowDays
+func RegisterCertificateExpiry(caClient CertificateSource, count int, windowDays int) {
+	prometheus.MustRegister(&certificateCollector{
+		caClient:   caClient,
+		count:      count,
+		windowDays: windowDays,
+		notAfter: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "certificate", "not_after_timestamp_seconds"),
+			"Expiry of the soonest expiring signed certificates as unix timestamp",
+			[]string{"certname"}, nil,
+		),
+		expiring: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "certificate", "expiring"),
+			"Number of signed certificates expiring within the window (including expired ones)",
+			[]string{"window_days"}, nil,
+		),
+		scrapeError: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "certificate", "scrape_error"),
+			"1 if the certificates could not be fetched from the puppet ca",
+			nil, nil,
+		),
+	})
+}
+
+func (c *certificateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.notAfter
+	ch <- c.expiring
+	ch <- c.scrapeError
+}
+
+func (c *certificateCollector) refresh() ([]model.ExpiringCertificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.fetchedAt) < certificateCacheTTL {
+		return c.certs, c.fetchErr
+	}
+
+	state := model.CertificateSigned
+	certs, err := c.caClient.GetCertificates(&state)
+	if err != nil {
+		slog.Error("error fetching certificates for metrics", "error", err)
+	}
+
+	c.fetchedAt = time.Now()
+	c.fetchErr = err
+	c.certs = model.ExpiringCertificates(certs, time.Now(), 0)
+
+	return c.certs, c.fetchErr
+}
+
+func (c *certificateCollector) Collect(ch chan<- prometheus.Metric) {
+	certs, err := c.refresh()
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(c.scrapeError, prometheus.GaugeValue, 1)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.scrapeError, prometheus.GaugeValue, 0)
+
+	expiring := 0
+	for i, cert := range certs {
+		if i < c.count {
+			ch <- prometheus.MustNewConstMetric(c.notAfter, prometheus.GaugeValue, float64(cert.NotAfter.Unix()), cert.Name)
+		}
+		if cert.DaysRemaining < c.windowDays {
+			expiring++
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.expiring, prometheus.GaugeValue, float64(expiring), strconv.Itoa(c.windowDays))
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sebastianrakel/openvoxview/model"
+)
+
+// fleetCacheTTL prevents that every scrape queries PuppetDB
+const fleetCacheTTL = time.Minute
+
+type fleetCollector struct {
+	fetchNodes      func() ([]model.Node, error)
+	unreportedHours uint64
+
+	mu        sync.Mutex
+	fetchedAt time.Time
+	nodes     []model.Node
+	fetchErr  error
+
+	nodesByStatus *prometheus.Desc
+	unreported    *prometheus.Desc
+	scrapeError   *prometheus.Desc
+}
+
+// RegisterFleet exports the number of nodes by their latest report status and the
+// number of unreported nodes
+func RegisterFleet(fetchNodes func() ([]model.Node, error), unreportedHours uint64) {
+	prometheus.MustRegister(&fleetCollector{
+		fetchNodes:      fetchNodes,
+		unreportedHours: unreportedHours,
+		nodesByStatus: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "fleet", "nodes"),
+			"Number of active nodes by latest report status",
+			[]string{"status"}, nil,
+		),
+		unreported: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "fleet", "nodes_unreported"),
+			"Number of active nodes without a report within the unreported hours",
+			nil, nil,
+		),
+		scrapeError: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "fleet", "scrape_error"),
+			"1 if the nodes could not be fetched from PuppetDB",
+			nil, nil,
+		),
+	})
+}
+
+func (c *fleetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nodesByStatus
+	ch <- c.unreported
+	ch <- c.scrapeError
+}
+
+func (c *fleetCollector) refresh() ([]model.Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.fetchedAt) < fleetCacheTTL {
+		return c.nodes, c.fetchErr
+	}
+
+	nodes, err := c.fetchNodes()
+	if err != nil {
+		slog.Error("error fetching nodes for metrics", "error", err)
+	}
+
+	c.fetchedAt = time.Now()
+	c.nodes = nodes
+	c.fetchErr = err
+
+	return c.nodes, c.fetchErr
+}
+
+func (c *fleetCollector) Collect(ch chan<- prometheus.Metric) {
+	nodes, err := c.refresh()
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(c.scrapeError, prometheus.GaugeValue, 1)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.scrapeError, prometheus.GaugeValue, 0)
+
+	now := time.Now()
+	byStatus := map[string]int{}
+	unreported := 0
+
+	for _, node := range nodes {
+		status := node.LatestReportStatus
+		if status == "" {
+			status = "none"
+		}
+		byStatus[status]++
+
+		if node.IsUnreported(now, c.unreportedHours) {
+			unreported++
+		}
+	}
+
+	for status, count := range byStatus {
+		ch <- prometheus.MustNewConstMetric(c.nodesByStatus, prometheus.GaugeValue, float64(count), status)
+	}
+	ch <- prometheus.MustNewConstMetric(c.unreported, prometheus.GaugeValue, float64(unreported))
+}
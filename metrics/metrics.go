@@ -0,0 +1,13 @@
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "openvoxview"
+
+// Handler serves all registered metrics in the prometheus format
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
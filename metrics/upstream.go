@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	UPSTREAM_PUPPETDB = "puppetdb"
+	UPSTREAM_PUPPETCA = "puppetca"
+)
+
+var (
+	upstreamRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of the requests to PuppetDB and Puppet CA",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"upstream", "method", "endpoint"})
+
+	upstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "errors_total",
+		Help:      "Number of failed requests to PuppetDB and Puppet CA (connection errors and status >= 400)",
+	}, []string{"upstream", "method", "endpoint"})
+)
+
+// ObserveUpstream records a request to an upstream service, the endpoint has to be
+// a template without names (e.g. puppet-ca/v1/certificate_status/:name) to keep the
+// label cardinality low
+func ObserveUpstream(upstream string, method string, endpoint string, start time.Time, statusCode int, err error) {
+	upstreamRequestDuration.WithLabelValues(upstream, method, endpoint).Observe(time.Since(start).Seconds())
+
+	if err != nil || statusCode >= 400 {
+		upstreamErrors.WithLabelValues(upstream, method, endpoint).Inc()
+	}
+}
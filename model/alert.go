@@ -0,0 +1,44 @@
+package model
+
+import "time"
+
+const (
+	AlertConditionFailed             = "failed"
+	AlertConditionUnreported         = "unreported"
+	AlertConditionCertificateRequest = "certificate_request"
+	AlertConditionCertificateExpiry  = "certificate_expiry"
+)
+
+var AlertConditions = []string{
+	AlertConditionFailed,
+	AlertConditionUnreported,
+	AlertConditionCertificateRequest,
+	AlertConditionCertificateExpiry,
+}
+
+// Alert is a node or certificate matching the condition of an alert rule. Key identifies
+// the state that caused it, e.g. the hash of the failed report, so the same state is only
+// notified once. A summary of several alerts has the nodes in Certnames instead of Certname.
+type Alert struct {
+	Rule        string    `json:"rule"`
+	Condition   string    `json:"condition"`
+	Certname    string    `json:"certname"`
+	Certnames   []string  `json:"certnames,omitempty"`
+	Environment string    `json:"environment,omitempty"`
+	Message     string    `json:"message"`
+	Key         string    `json:"key"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// AlertState is the last notification of a rule about a node on one channel
+type AlertState struct {
+	Rule     string    `json:"rule"`
+	Channel  string    `json:"channel"`
+	Certname string    `json:"certname"`
+	Key      string    `json:"key"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+func (s AlertState) Id() string {
+	return s.Rule + "/" + s.Channel + "/" + s.Certname
+}
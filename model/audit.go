@@ -0,0 +1,52 @@
+package model
+
+import "time"
+
+const (
+	AuditActionSign       = "sign"
+	AuditActionRevoke     = "revoke"
+	AuditActionClean      = "clean"
+	AuditActionDeactivate = "deactivate"
+
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+type AuditEvent struct {
+	Id               uint64            `json:"id"`
+	Timestamp        time.Time         `json:"timestamp"`
+	User             string            `json:"user"`
+	Action           string            `json:"action"`
+	Certname         string            `json:"certname"`
+	PriorState       *CertificateState `json:"prior_state,omitempty"`
+	PriorFingerprint string            `json:"prior_fingerprint,omitempty"`
+	Outcome          string            `json:"outcome"`
+	Error            string            `json:"error,omitempty"`
+	CommandUuid      string            `json:"command_uuid,omitempty"`
+	Reason           string            `json:"reason,omitempty"`
+}
+
+type AuditEventQuery struct {
+	Certname string    `form:"certname"`
+	User     string    `form:"user"`
+	Action   string    `form:"action"`
+	Outcome  string    `form:"outcome"`
+	Since    time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until    time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	Offset   int       `form:"offset" binding:"min=0"`
+	Limit    int       `form:"limit" binding:"min=0"`
+}
+
+func (q *AuditEventQuery) Matches(event AuditEvent) bool {
+	return (q.Certname == "" || q.Certname == event.Certname) &&
+		(q.User == "" || q.User == event.User) &&
+		(q.Action == "" || q.Action == event.Action) &&
+		(q.Outcome == "" || q.Outcome == event.Outcome) &&
+		(q.Since.IsZero() || !event.Timestamp.Before(q.Since)) &&
+		(q.Until.IsZero() || event.Timestamp.Before(q.Until))
+}
+
+type AuditEventResponse struct {
+	Events []AuditEvent
+	Total  int
+}
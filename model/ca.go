@@ -3,6 +3,8 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"slices"
 	"strings"
 	"time"
 )
@@ -129,6 +131,98 @@ type CertificateStatusResponse struct {
 	CertificateStatuses []CertificateStatus `json:"certificate_statuses"`
 }
 
+// CertificateBulkRequest selects the certificates of a bulk operation, either by name
+// or by a status query, both are combined
+type CertificateBulkRequest struct {
+	Certnames []string                `json:"certnames"`
+	Query     *CertificateStatusQuery `json:"query"`
+}
+
+type CertificateBulkResult struct {
+	Certname string `json:"certname"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+}
+
+type ExpiringCertificate struct {
+	Name          string           `json:"name"`
+	State         CertificateState `json:"state"`
+	NotAfter      PuppetTime       `json:"not_after"`
+	DaysRemaining int              `json:"days_remaining"`
+}
+
+type CertificateExpiryGroup struct {
+	DaysRemaining int                   `json:"days_remaining"`
+	Certificates  []ExpiringCertificate `json:"certificates"`
+}
+
+type CertificateExpiryResponse struct {
+	WindowDays int                      `json:"window_days"`
+	Total      int                      `json:"total"`
+	Groups     []CertificateExpiryGroup `json:"groups"`
+}
+
+// ExpiringCertificates returns the certificates with a NotAfter before now+window (all
+// certificates for a window of 0), soonest first. Already expired certificates have a
+// negative DaysRemaining.
+func ExpiringCertificates(certs []CertificateStatus, now time.Time, window time.Duration) []ExpiringCertificate {
+	result := []ExpiringCertificate{}
+
+	for _, cert := range certs {
+		if cert.NotAfter == nil || (window > 0 && cert.NotAfter.After(now.Add(window))) {
+			continue
+		}
+
+		remaining := cert.NotAfter.Sub(now)
+		result = append(result, ExpiringCertificate{
+			Name:          cert.Name,
+			State:         cert.State,
+			NotAfter:      *cert.NotAfter,
+			DaysRemaining: int(math.Floor(remaining.Hours() / 24)),
+		})
+	}
+
+	slices.SortFunc(result, func(a, b ExpiringCertificate) int {
+		return a.NotAfter.Compare(b.NotAfter.Time)
+	})
+
+	return result
+}
+
+// GroupExpiringCertificates groups the certificates sorted by ExpiringCertificates by their days remaining
+func GroupExpiringCertificates(certs []ExpiringCertificate) []CertificateExpiryGroup {
+	groups := []CertificateExpiryGroup{}
+
+	for _, cert := range certs {
+		if len(groups) == 0 || groups[len(groups)-1].DaysRemaining != cert.DaysRemaining {
+			groups = append(groups, CertificateExpiryGroup{
+				DaysRemaining: cert.DaysRemaining,
+			})
+		}
+
+		group := &groups[len(groups)-1]
+		group.Certificates = append(group.Certificates, cert)
+	}
+
+	return groups
+}
+
+// AutosignDecision explains whether a pending certificate request matches one of
+// the autosign policies
+type AutosignDecision struct {
+	Certname    string   `json:"certname"`
+	Fingerprint string   `json:"fingerprint"`
+	Sign        bool     `json:"sign"`
+	Policy      string   `json:"policy,omitempty"`
+	Reasons     []string `json:"reasons"`
+}
+
+type CertificateBulkResponse struct {
+	Results   []CertificateBulkResult `json:"results"`
+	Succeeded int                     `json:"succeeded"`
+	Failed    int                     `json:"failed"`
+}
+
 // puppetCaTimeLayout is the non-standard format used by OpenVox Server, Puppet Server,
 // OpenVoxDB, and PuppetDB (Clojure implementations), which emit a named timezone
 // abbreviation (e.g. "UTC", "EST") rather than the ISO 8601 "Z" suffix.
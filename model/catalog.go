@@ -0,0 +1,44 @@
+package model
+
+// Catalog as returned by the PuppetDB catalogs endpoint:
+// https://github.com/OpenVoxProject/openvoxdb/blob/8.12.1/documentation/api/query/v4/catalogs.markdown#response-format
+type Catalog struct {
+	Certname          string                         `json:"certname"`
+	Version           string                         `json:"version"`
+	Environment       string                         `json:"environment"`
+	TransactionUuid   *string                        `json:"transaction_uuid"`
+	CatalogUuid       *string                        `json:"catalog_uuid"`
+	CodeId            *string                        `json:"code_id"`
+	JobId             *string                        `json:"job_id"`
+	Hash              string                         `json:"hash"`
+	Producer          *string                        `json:"producer"`
+	ProducerTimestamp *PuppetTime                    `json:"producer_timestamp"`
+	Resources         *ExpandedList[CatalogResource] `json:"resources,omitempty"`
+	Edges             *ExpandedList[CatalogEdge]     `json:"edges,omitempty"`
+}
+
+type CatalogResource struct {
+	Type       string         `json:"type"`
+	Title      string         `json:"title"`
+	Tags       []string       `json:"tags"`
+	Exported   bool           `json:"exported"`
+	File       *string        `json:"file"`
+	Line       *int           `json:"line"`
+	Parameters map[string]any `json:"parameters"`
+}
+
+type CatalogEdge struct {
+	SourceType   string `json:"source_type"`
+	SourceTitle  string `json:"source_title"`
+	TargetType   string `json:"target_type"`
+	TargetTitle  string `json:"target_title"`
+	Relationship string `json:"relationship"`
+}
+
+// CatalogDiff lists the changes between two catalogs, the catalogs are returned
+// without their resources and edges
+type CatalogDiff struct {
+	From    Catalog
+	To      Catalog
+	Changes []Change
+}
@@ -0,0 +1,34 @@
+package model
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+
+	DiffSectionReport    = "report"
+	DiffSectionCatalog   = "catalog"
+	DiffSectionResource  = "resource"
+	DiffSectionEvent     = "event"
+	DiffSectionLog       = "log"
+	DiffSectionMetric    = "metric"
+	DiffSectionParameter = "parameter"
+	DiffSectionEdge      = "edge"
+	DiffSectionFact      = "fact"
+)
+
+// Change is a single difference, Old is empty for added and New for removed entries
+type Change struct {
+	Type    string `json:"type"`
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	Old     any    `json:"old,omitempty"`
+	New     any    `json:"new,omitempty"`
+}
+
+// ReportDiff lists the changes between two reports, the reports are returned
+// without their metrics, logs and events
+type ReportDiff struct {
+	From    Report
+	To      Report
+	Changes []Change
+}
@@ -1,8 +1,119 @@
 package model
 
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
 type Fact struct {
 	Certname    string `json:"certname"`
 	Name        string `json:"name"`
 	Environment string `json:"environment"`
 	Value       any    `json:"value"`
 }
+
+// Inventory is a node with all its facts as returned by the PuppetDB inventory endpoint:
+// https://github.com/OpenVoxProject/openvoxdb/blob/8.12.1/documentation/api/query/v4/inventory.markdown#response-format
+type Inventory struct {
+	Certname    string         `json:"certname"`
+	Timestamp   *PuppetTime    `json:"timestamp"`
+	Environment string         `json:"environment"`
+	Facts       map[string]any `json:"facts"`
+	Trusted     map[string]any `json:"trusted"`
+}
+
+// FactLeaves flattens a structured fact into its leaf values keyed by the dotted path
+// (e.g. os.release.major, array elements by index: processors.models.0). Empty maps
+// and arrays are leaves themselves.
+func FactLeaves(name string, value any) map[string]any {
+	leaves := map[string]any{}
+	collectFactLeaves(leaves, name, value)
+	return leaves
+}
+
+func collectFactLeaves(leaves map[string]any, path string, value any) {
+	switch typed := value.(type) {
+	case map[string]any:
+		if len(typed) == 0 {
+			leaves[path] = typed
+		}
+		for key, child := range typed {
+			collectFactLeaves(leaves, fmt.Sprintf("%s.%s", path, key), child)
+		}
+	case []any:
+		if len(typed) == 0 {
+			leaves[path] = typed
+		}
+		for i, child := range typed {
+			collectFactLeaves(leaves, path+"."+strconv.Itoa(i), child)
+		}
+	default:
+		leaves[path] = value
+	}
+}
+
+// FactRoot returns the name of the fact of a dotted path (e.g. networking for networking.ip)
+func FactRoot(path string) string {
+	root, _, _ := strings.Cut(path, ".")
+	return root
+}
+
+// ResolveFactPath returns the value at the dotted path in the facts of a node, the
+// first segment is the fact name. Array elements are addressed by index, * matches all
+// elements of a map (sorted by key) or an array, e.g. disks.*.size. Paths with a
+// wildcard return the list of all matches, missing values are nil.
+func ResolveFactPath(facts map[string]any, path string) any {
+	current := []any{facts}
+	wildcard := false
+
+	for _, segment := range strings.Split(path, ".") {
+		next := []any{}
+
+		for _, value := range current {
+			switch typed := value.(type) {
+			case map[string]any:
+				if segment == "*" {
+					for _, key := range slices.Sorted(maps.Keys(typed)) {
+						next = append(next, typed[key])
+					}
+				} else if child, exists := typed[segment]; exists {
+					next = append(next, child)
+				}
+			case []any:
+				if segment == "*" {
+					next = append(next, typed...)
+				} else if index, err := strconv.Atoi(segment); err == nil && index >= 0 && index < len(typed) {
+					next = append(next, typed[index])
+				}
+			}
+		}
+
+		wildcard = wildcard || segment == "*"
+		current = next
+	}
+
+	if wildcard {
+		return current
+	}
+
+	if len(current) == 0 {
+		return nil
+	}
+
+	return current[0]
+}
+
+// FactDifference is a leaf value which is not the same on all compared nodes, nodes
+// without the fact are missing in Values
+type FactDifference struct {
+	Path   string         `json:"path"`
+	Values map[string]any `json:"values"`
+}
+
+type FactDiff struct {
+	Certnames   []string
+	Differences []FactDifference
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	FleetEventReport             = "report"
+	FleetEventStatus             = "status"
+	FleetEventUnreported         = "unreported"
+	FleetEventCertificateRequest = "certificate_request"
+)
+
+var FleetEventTypes = []string{
+	FleetEventReport,
+	FleetEventStatus,
+	FleetEventUnreported,
+	FleetEventCertificateRequest,
+}
+
+// FleetEvent is a change found by comparing two polls of PuppetDB and Puppet CA
+type FleetEvent struct {
+	Id              uint64      `json:"id"`
+	Type            string      `json:"type"`
+	Timestamp       time.Time   `json:"timestamp"`
+	Certname        string      `json:"certname"`
+	Environment     string      `json:"environment,omitempty"`
+	Status          string      `json:"status,omitempty"`
+	PreviousStatus  string      `json:"previous_status,omitempty"`
+	ReportHash      string      `json:"report_hash,omitempty"`
+	ReportTimestamp *PuppetTime `json:"report_timestamp,omitempty"`
+	Fingerprint     string      `json:"fingerprint,omitempty"`
+}
+
+// FleetEventQuery selects the events of a subscriber, every list is ignored when empty.
+// Certificate requests have no environment and pass every environment filter.
+type FleetEventQuery struct {
+	Environments []string `form:"environment"`
+	Types        []string `form:"type"`
+}
+
+func (q *FleetEventQuery) Validate() error {
+	for _, eventType := range q.Types {
+		if !slices.Contains(FleetEventTypes, eventType) {
+			return fmt.Errorf("%q is not a valid event type", eventType)
+		}
+	}
+	return nil
+}
+
+func (q *FleetEventQuery) Matches(event FleetEvent) bool {
+	return (len(q.Types) == 0 || slices.Contains(q.Types, event.Type)) &&
+		(len(q.Environments) == 0 || event.Environment == "" || slices.Contains(q.Environments, event.Environment))
+}
@@ -5,11 +5,23 @@ type Metric struct {
 		Mbean string `json:"mbean"`
 		Type  string `json:"type"`
 	} `json:"request"`
-	Value struct {
-		Value any `json:"Value"`
-	} `json:"value"`
-	Timestamp uint `json:"timestamp"`
-	Status    uint `json:"status"`
+	Value     map[string]any `json:"value"`
+	Error     string         `json:"error,omitempty"`
+	Timestamp uint           `json:"timestamp"`
+	Status    uint           `json:"status"`
+}
+
+// Float returns the numeric value of the given mbean attribute, or false if the
+// attribute is missing or not a number.
+func (m Metric) Float(attribute string) (float64, bool) {
+	value, ok := m.Value[attribute].(float64)
+	return value, ok
+}
+
+// Object returns a composite mbean attribute like java.lang:type=Memory HeapMemoryUsage
+func (m Metric) Object(attribute string) (map[string]any, bool) {
+	value, ok := m.Value[attribute].(map[string]any)
+	return value, ok
 }
 
 type MetricInfoAttr struct {
@@ -35,5 +47,37 @@ type MetricList struct {
 	Request struct {
 		Type string
 	}
-	Value map[string]MetricInfo
+	// Value is keyed by mbean domain (e.g. puppetlabs.puppetdb.mq) and then by the
+	// mbean properties (e.g. name=global.depth)
+	Value map[string]map[string]MetricInfo
+}
+
+// MetricTimer summarizes a codahale timer mbean, durations are in milliseconds
+type MetricTimer struct {
+	Count float64
+	Mean  float64
+	P95   float64
+	P99   float64
+	Max   float64
+}
+
+type MetricHeap struct {
+	Used      float64
+	Committed float64
+	Max       float64
+}
+
+// MetricsSummary is a curated set of PuppetDB health metrics
+type MetricsSummary struct {
+	CommandQueueDepth         *float64
+	CommandProcessingRate     *float64
+	CommandsProcessed         *float64
+	CommandProcessingTime     *MetricTimer
+	StorageReplaceCatalogTime *MetricTimer
+	StorageReplaceFactsTime   *MetricTimer
+	StorageStoreReportTime    *MetricTimer
+	DloSizeInBytes            *float64
+	DloMessages               *float64
+	JvmHeap                   *MetricHeap
+	Errors                    map[string]string
 }
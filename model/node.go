@@ -1,5 +1,10 @@
 package model
 
+import (
+	"math"
+	"time"
+)
+
 type Node struct {
 	// Fields in OpenVoxDB nodes response format:
 	// https://github.com/OpenVoxProject/openvoxdb/blob/8.12.1/documentation/api/query/v4/nodes.markdown#response-format
@@ -23,7 +28,39 @@ type Node struct {
 	LatestReportCorrectiveChange *bool   `json:"latest_report_corrective_change"`
 
 	// Additional fields for our use, not in the OpenVoxDB API response:
-	Events EventCount `json:"events"`
+	Events           EventCount `json:"events"`
+	Unreported       bool       `json:"unreported"`
+	HoursSinceReport *float64   `json:"hours_since_report"`
+}
+
+const NodeStatusUnreported = "unreported"
+
+// IsUnreported is true if the node has no report within the last unreportedHours
+func (n Node) IsUnreported(now time.Time, unreportedHours uint64) bool {
+	if n.ReportTimestamp == nil {
+		return true
+	}
+
+	return now.Sub(n.ReportTimestamp.Time) > time.Duration(unreportedHours)*time.Hour
+}
+
+// ReportAgeHours returns the hours since the latest report, rounded to two decimals, and
+// nil for nodes without report
+func (n Node) ReportAgeHours(now time.Time) *float64 {
+	if n.ReportTimestamp == nil {
+		return nil
+	}
+
+	hours := math.Round(now.Sub(n.ReportTimestamp.Time).Hours()*100) / 100
+	return &hours
+}
+
+// Environment is the environment of the latest catalog, empty without catalog
+func (n Node) Environment() string {
+	if n.CatalogEnvironment == nil {
+		return ""
+	}
+	return *n.CatalogEnvironment
 }
 
 func NodeFromData(nodeData map[string]interface{}, eventData interface{}) Node {
@@ -31,3 +68,14 @@ func NodeFromData(nodeData map[string]interface{}, eventData interface{}) Node {
 		Name: nodeData["certname"].(string),
 	}
 }
+
+// NodeDetail aggregates everything known about a node, parts which could not be
+// fetched are reported in Errors
+type NodeDetail struct {
+	Node         Node
+	LatestReport *Report
+	Facts        map[string]any
+	Catalog      *Catalog
+	Certificate  *CertificateStatus
+	Errors       map[string]string
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type QueryRequest struct {
+	Query         string
+	SaveInHistory bool
+	// Unlimited opts out of the default limit, if query_limits.allow_unlimited is set
+	Unlimited bool
+}
+
+type QueryResult struct {
+	Data                 []json.RawMessage
+	Error                string
+	Success              bool
+	ExecutedOn           time.Time
+	ExecutionTimeInMilli int64
+	Count                int
+	Truncated            bool
+}
+
+// QueryHistoryEntry is an executed PQL query, the result data itself is not kept
+type QueryHistoryEntry struct {
+	Id     uint64
+	User   string
+	Pinned bool
+	Query  QueryRequest
+	Result QueryResult
+}
+
+type QueryHistoryResponse struct {
+	Entries []QueryHistoryEntry
+	Total   int
+}
@@ -0,0 +1,82 @@
+package model
+
+// ExpandedList is a related collection of a PuppetDB entity (e.g. the metrics of a
+// report), data is only filled when PuppetDB expanded it in the response. It is nil
+// when the field was not extracted.
+type ExpandedList[T any] struct {
+	Data []T    `json:"data"`
+	Href string `json:"href"`
+}
+
+// Report as returned by the PuppetDB reports endpoint:
+// https://github.com/OpenVoxProject/openvoxdb/blob/8.12.1/documentation/api/query/v4/reports.markdown#response-format
+type Report struct {
+	Hash                 string                       `json:"hash"`
+	Certname             string                       `json:"certname"`
+	PuppetVersion        string                       `json:"puppet_version"`
+	ReportFormat         int                          `json:"report_format"`
+	ConfigurationVersion string                       `json:"configuration_version"`
+	StartTime            *PuppetTime                  `json:"start_time"`
+	EndTime              *PuppetTime                  `json:"end_time"`
+	ProducerTimestamp    *PuppetTime                  `json:"producer_timestamp"`
+	ReceiveTime          *PuppetTime                  `json:"receive_time"`
+	Producer             *string                      `json:"producer"`
+	TransactionUuid      *string                      `json:"transaction_uuid"`
+	CatalogUuid          *string                      `json:"catalog_uuid"`
+	CodeId               *string                      `json:"code_id"`
+	JobId                *string                      `json:"job_id"`
+	CachedCatalogStatus  *string                      `json:"cached_catalog_status"`
+	Noop                 bool                         `json:"noop"`
+	NoopPending          *bool                        `json:"noop_pending"`
+	CorrectiveChange     *bool                        `json:"corrective_change"`
+	Environment          string                       `json:"environment"`
+	Status               string                       `json:"status"`
+	Type                 string                       `json:"type"`
+	Metrics              *ExpandedList[ReportMetric]  `json:"metrics,omitempty"`
+	Logs                 *ExpandedList[ReportLog]     `json:"logs,omitempty"`
+	Events               *ExpandedList[ResourceEvent] `json:"resource_events,omitempty"`
+}
+
+type ReportMetric struct {
+	Category string  `json:"category"`
+	Name     string  `json:"name"`
+	Value    float64 `json:"value"`
+}
+
+type ReportLog struct {
+	File    *string     `json:"file"`
+	Line    *int        `json:"line"`
+	Level   string      `json:"level"`
+	Message string      `json:"message"`
+	Source  string      `json:"source"`
+	Tags    []string    `json:"tags"`
+	Time    *PuppetTime `json:"time"`
+}
+
+// ResourceEvent as returned by the PuppetDB events endpoint and in the resource_events of a report:
+// https://github.com/OpenVoxProject/openvoxdb/blob/8.12.1/documentation/api/query/v4/events.markdown#response-format
+type ResourceEvent struct {
+	Certname             string      `json:"certname"`
+	Report               string      `json:"report"`
+	Status               string      `json:"status"`
+	Timestamp            *PuppetTime `json:"timestamp"`
+	ResourceType         string      `json:"resource_type"`
+	ResourceTitle        string      `json:"resource_title"`
+	Property             *string     `json:"property"`
+	Name                 *string     `json:"name"`
+	NewValue             any         `json:"new_value"`
+	OldValue             any         `json:"old_value"`
+	Message              *string     `json:"message"`
+	File                 *string     `json:"file"`
+	Line                 *int        `json:"line"`
+	ContainmentPath      []string    `json:"containment_path"`
+	ContainingClass      *string     `json:"containing_class"`
+	CorrectiveChange     *bool       `json:"corrective_change"`
+	ConfigurationVersion string      `json:"configuration_version"`
+	Environment          string      `json:"environment"`
+}
+
+type ReportsResponse struct {
+	Reports []Report
+	Total   int
+}
@@ -1,5 +1,18 @@
 package model
 
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ViewCertnameColumn is added to every row of a view result
+const ViewCertnameColumn = "certname"
+
 type View struct {
 	Name               string     `mapstructure:"name"`
 	Facts              []ViewFact `mapstructure:"facts"`
@@ -13,6 +26,201 @@ type ViewFact struct {
 }
 
 type ViewResult struct {
-	View View
-	Data any
+	View  View
+	Data  any
+	Total int
+}
+
+// HasColumn is true for the name of a view fact and the certname column
+func (v *View) HasColumn(column string) bool {
+	return column == ViewCertnameColumn || slices.ContainsFunc(v.Facts, func(fact ViewFact) bool {
+		return fact.Name == column
+	})
+}
+
+// Columns returns the certname and the names of the view facts in their configured order
+func (v *View) Columns() []string {
+	columns := []string{ViewCertnameColumn}
+	for _, fact := range v.Facts {
+		columns = append(columns, fact.Name)
+	}
+	return columns
+}
+
+// RowValues returns the values of a result row in the order of Columns
+func (v *View) RowValues(row map[string]any) []any {
+	values := []any{row[ViewCertnameColumn]}
+	for _, fact := range v.Facts {
+		values = append(values, row[fact.Name])
+	}
+	return values
+}
+
+// viewFilterOperators are checked in order, so the two character operators come first
+var viewFilterOperators = []string{"!=", ">=", "<=", "!~", "=", "~", ">", "<"}
+
+// ViewFilter is a filter expression on a column of a view like Role=web, OS~^Rocky or
+// Memory>=4096. Lists (wildcard facts) match if one of their elements matches.
+type ViewFilter struct {
+	Column   string
+	Operator string
+	Value    string
+
+	regex *regexp.Regexp
+}
+
+func ParseViewFilter(expression string) (*ViewFilter, error) {
+	position := -1
+	operator := ""
+
+	for _, candidate := range viewFilterOperators {
+		index := strings.Index(expression, candidate)
+		if index > 0 && (position < 0 || index < position) {
+			position = index
+			operator = candidate
+		}
+	}
+
+	if position < 0 {
+		return nil, fmt.Errorf("filter %q has no operator (%s)", expression, strings.Join(viewFilterOperators, " "))
+	}
+
+	filter := &ViewFilter{
+		Column:   strings.TrimSpace(expression[:position]),
+		Operator: operator,
+		Value:    strings.TrimSpace(expression[position+len(operator):]),
+	}
+
+	if operator == "~" || operator == "!~" {
+		regex, err := regexp.Compile(filter.Value)
+		if err != nil {
+			return nil, fmt.Errorf("filter %q: %w", expression, err)
+		}
+		filter.regex = regex
+	}
+
+	return filter, nil
+}
+
+func (f *ViewFilter) Matches(row map[string]any) bool {
+	value := row[f.Column]
+
+	switch f.Operator {
+	case "!=":
+		return !f.matches(value, "=")
+	case "!~":
+		return !f.matches(value, "~")
+	default:
+		return f.matches(value, f.Operator)
+	}
+}
+
+func (f *ViewFilter) matches(value any, operator string) bool {
+	if list, isList := value.([]any); isList {
+		return slices.ContainsFunc(list, func(element any) bool {
+			return f.matches(element, operator)
+		})
+	}
+
+	if value == nil {
+		return false
+	}
+
+	text := viewValueString(value)
+
+	switch operator {
+	case "=":
+		return text == f.Value
+	case "~":
+		return f.regex.MatchString(text)
+	}
+
+	result, comparable := compareViewValues(text, f.Value)
+	if !comparable {
+		return false
+	}
+
+	switch operator {
+	case ">":
+		return result > 0
+	case "<":
+		return result < 0
+	case ">=":
+		return result >= 0
+	case "<=":
+		return result <= 0
+	}
+
+	return false
+}
+
+func viewValueString(value any) string {
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case map[string]any, []any:
+		data, _ := json.Marshal(typed)
+		return string(data)
+	default:
+		return fmt.Sprint(typed)
+	}
+}
+
+// compareViewValues compares numbers numerically and everything else as text,
+// ordering numbers against text is not possible
+func compareViewValues(a string, b string) (int, bool) {
+	numberA, errA := strconv.ParseFloat(a, 64)
+	numberB, errB := strconv.ParseFloat(b, 64)
+
+	switch {
+	case errA == nil && errB == nil:
+		return cmp.Compare(numberA, numberB), true
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b), true
+	}
+
+	return 0, false
+}
+
+// SortViewRows sorts the rows by the column, rows without value come last in both
+// directions. Rows with the same value are ordered by certname, so pages are stable.
+func SortViewRows(rows []map[string]any, column string, descending bool) {
+	slices.SortStableFunc(rows, func(a, b map[string]any) int {
+		valueA, valueB := a[column], b[column]
+
+		switch {
+		case valueA == nil && valueB != nil:
+			return 1
+		case valueA != nil && valueB == nil:
+			return -1
+		}
+
+		result := 0
+		if valueA != nil {
+			result = compareViewRowValues(valueA, valueB)
+		}
+
+		if descending {
+			result = -result
+		}
+
+		if result == 0 && column != ViewCertnameColumn {
+			return strings.Compare(viewValueString(a[ViewCertnameColumn]), viewValueString(b[ViewCertnameColumn]))
+		}
+
+		return result
+	})
+}
+
+func compareViewRowValues(a any, b any) int {
+	textA, textB := viewValueString(a), viewValueString(b)
+	if result, comparable := compareViewValues(textA, textB); comparable {
+		return result
+	}
+
+	// numbers before text
+	if _, err := strconv.ParseFloat(textA, 64); err == nil {
+		return -1
+	}
+	return 1
 }
@@ -11,13 +11,18 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/metrics"
 	"github.com/sebastianrakel/openvoxview/model"
 )
 
 type Client struct {
 	config    *config.Config
+	mu        sync.Mutex
 	transport *http.Transport
 }
 
@@ -29,6 +34,24 @@ func NewClient(config *config.Config) *Client {
 }
 
 func (c *Client) call(httpMethod string, endpoint string, payload any, query url.Values, responseData any) (*http.Response, int, error) {
+	start := time.Now()
+	resp, statusCode, err := c.do(httpMethod, endpoint, payload, query, responseData)
+	metrics.ObserveUpstream(metrics.UPSTREAM_PUPPETCA, httpMethod, endpointLabel(endpoint), start, statusCode, err)
+
+	return resp, statusCode, err
+}
+
+// endpointLabel strips the certname from the endpoint, so the metrics have a bounded
+// number of endpoints
+func endpointLabel(endpoint string) string {
+	if strings.HasPrefix(endpoint, "puppet-ca/v1/certificate_status/") {
+		return "puppet-ca/v1/certificate_status/:name"
+	}
+
+	return endpoint
+}
+
+func (c *Client) do(httpMethod string, endpoint string, payload any, query url.Values, responseData any) (*http.Response, int, error) {
 	uri := fmt.Sprintf("%s/%s", c.config.GetPuppetCAAddress(), endpoint)
 	if query != nil {
 		uri = fmt.Sprintf("%s?%s", uri, query.Encode())
@@ -46,41 +69,13 @@ func (c *Client) call(httpMethod string, endpoint string, payload any, query url
 
 	slog.Debug("puppet ca call", "method", httpMethod, "url", uri)
 
-	if c.transport == nil {
-		var tlsConfig *tls.Config
-
-		if c.config.PuppetCA.TLS {
-			tlsConfig = &tls.Config{
-				InsecureSkipVerify: c.config.PuppetCA.TLSIgnore,
-			}
-
-			if c.config.PuppetCA.TLS_CA != "" {
-				caCert, err := os.ReadFile(c.config.PuppetCA.TLS_CA)
-				if err != nil {
-					return nil, 0, err
-				}
-				caCertPool := x509.NewCertPool()
-				caCertPool.AppendCertsFromPEM(caCert)
-				tlsConfig.RootCAs = caCertPool
-			}
-
-			if c.config.PuppetCA.TLS_KEY != "" {
-				cer, err := tls.LoadX509KeyPair(c.config.PuppetCA.TLS_CERT, c.config.PuppetCA.TLS_KEY)
-				if err != nil {
-					return nil, 0, err
-				}
-
-				tlsConfig.Certificates = []tls.Certificate{cer}
-			}
-		}
-
-		c.transport = &http.Transport{
-			TLSClientConfig: tlsConfig,
-		}
+	transport, err := c.getTransport()
+	if err != nil {
+		return nil, 0, err
 	}
 
 	httpClient := &http.Client{
-		Transport: c.transport,
+		Transport: transport,
 	}
 
 	req, err := http.NewRequest(httpMethod, uri, bytes.NewBuffer(data))
@@ -113,6 +108,47 @@ func (c *Client) call(httpMethod string, endpoint string, payload any, query url
 	return resp, resp.StatusCode, nil
 }
 
+// getTransport returns the shared transport, it is created on first use
+func (c *Client) getTransport() (*http.Transport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.transport == nil {
+		var tlsConfig *tls.Config
+
+		if c.config.PuppetCA.TLS {
+			tlsConfig = &tls.Config{
+				InsecureSkipVerify: c.config.PuppetCA.TLSIgnore,
+			}
+
+			if c.config.PuppetCA.TLS_CA != "" {
+				caCert, err := os.ReadFile(c.config.PuppetCA.TLS_CA)
+				if err != nil {
+					return nil, err
+				}
+				caCertPool := x509.NewCertPool()
+				caCertPool.AppendCertsFromPEM(caCert)
+				tlsConfig.RootCAs = caCertPool
+			}
+
+			if c.config.PuppetCA.TLS_KEY != "" {
+				cer, err := tls.LoadX509KeyPair(c.config.PuppetCA.TLS_CERT, c.config.PuppetCA.TLS_KEY)
+				if err != nil {
+					return nil, err
+				}
+
+				tlsConfig.Certificates = []tls.Certificate{cer}
+			}
+		}
+
+		c.transport = &http.Transport{
+			TLSClientConfig: tlsConfig,
+		}
+	}
+
+	return c.transport, nil
+}
+
 func (c *Client) GetCertificates(state *model.CertificateState) ([]model.CertificateStatus, error) {
 	var resp []model.CertificateStatus
 
@@ -0,0 +1,186 @@
+package puppetdb
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sebastianrakel/openvoxview/metrics"
+	"github.com/sebastianrakel/openvoxview/puppetdb/query"
+	"golang.org/x/sync/singleflight"
+)
+
+// rawResponse is a PuppetDB response before it is decoded, so it can be cached and
+// decoded for every caller
+type rawResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+type cacheEntry struct {
+	response *rawResponse
+	expires  time.Time
+}
+
+// responseCache keeps successful PuppetDB responses for the ttl of their endpoint and
+// lets concurrent identical requests share one request to PuppetDB
+type responseCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	group   singleflight.Group
+}
+
+func newResponseCache(maxEntries int) *responseCache {
+	return &responseCache{
+		maxEntries: maxEntries,
+		entries:    map[string]cacheEntry{},
+	}
+}
+
+// cacheKey normalizes the query, so queries that only differ in whitespace or quoting
+// share a cache entry
+func (r *pqlRequest) cacheKey() string {
+	parsed, err := query.Parse(r.Query)
+	if err != nil {
+		return r.Query
+	}
+	return parsed.String()
+}
+
+// cacheEndpoint is the name of the endpoint in cache.ttl and the metrics, e.g. nodes for
+// pdb/query/v4/nodes and pdb/query/v4/nodes/<certname> or query for raw PQL queries
+func cacheEndpoint(endpoint string) string {
+	switch {
+	case endpoint == "pdb/query/v4":
+		return "query"
+	case strings.HasPrefix(endpoint, "pdb/query/v4/"):
+		name, _, _ := strings.Cut(strings.TrimPrefix(endpoint, "pdb/query/v4/"), "/")
+		return name
+	case strings.HasPrefix(endpoint, "metrics/"):
+		return "metrics"
+	}
+
+	return ""
+}
+
+// get returns the cached response or fetches it, with bypass the cached response is
+// ignored and replaced by the fetched one. The fetch is shared by all callers of the
+// key, so it runs without their cancellation, but keeps the deadline of the caller that
+// started it (e.g. query_limits.timeout) and puppetdb.timeout. Every caller stops
+// waiting when its own context is done.
+func (rc *responseCache) get(ctx context.Context, endpoint string, key string, ttl time.Duration, bypass bool, fetch func(ctx context.Context) (*rawResponse, error)) (*rawResponse, error) {
+	if !bypass {
+		if response, found := rc.lookup(key); found {
+			metrics.ObserveCache(endpoint, metrics.CACHE_HIT)
+			return response, nil
+		}
+	}
+
+	// shared is also set for the caller that fetched, when others waited for it
+	fetched := false
+	results := rc.group.DoChan(key, func() (any, error) {
+		fetched = true
+
+		detached := context.WithoutCancel(ctx)
+		if deadline, hasDeadline := ctx.Deadline(); hasDeadline {
+			var cancel context.CancelFunc
+			detached, cancel = context.WithDeadline(detached, deadline)
+			defer cancel()
+		}
+
+		response, err := fetch(detached)
+		if err == nil && response.statusCode == http.StatusOK {
+			rc.store(key, endpoint, response, ttl)
+		}
+		return response, err
+	})
+
+	var result singleflight.Result
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	switch {
+	case !fetched:
+		metrics.ObserveCache(endpoint, metrics.CACHE_COALESCED)
+	case bypass:
+		metrics.ObserveCache(endpoint, metrics.CACHE_BYPASS)
+	default:
+		metrics.ObserveCache(endpoint, metrics.CACHE_MISS)
+	}
+
+	response, _ := result.Val.(*rawResponse)
+	return response, result.Err
+}
+
+func (rc *responseCache) lookup(key string) (*rawResponse, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	entry, found := rc.entries[key]
+	if !found || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.response, true
+}
+
+// store adds the response, when the cache is full expired entries are removed first and
+// then the entry expiring next
+func (rc *responseCache) store(key string, endpoint string, response *rawResponse, ttl time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	now := time.Now()
+
+	if _, exists := rc.entries[key]; !exists && rc.maxEntries > 0 && len(rc.entries) >= rc.maxEntries {
+		for existingKey, entry := range rc.entries {
+			if now.After(entry.expires) {
+				delete(rc.entries, existingKey)
+			}
+		}
+
+		for len(rc.entries) >= rc.maxEntries {
+			oldestKey := ""
+			var oldest time.Time
+			for existingKey, entry := range rc.entries {
+				if oldestKey == "" || entry.expires.Before(oldest) {
+					oldestKey, oldest = existingKey, entry.expires
+				}
+			}
+			delete(rc.entries, oldestKey)
+		}
+	}
+
+	rc.entries[key] = cacheEntry{
+		response: response,
+		expires:  now.Add(ttl),
+	}
+}
+
+// invalidate removes all entries of the endpoint
+func (rc *responseCache) invalidate(endpoint string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	prefix := endpoint + "\n"
+	for key := range rc.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(rc.entries, key)
+		}
+	}
+}
+
+func (rc *responseCache) len() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return len(rc.entries)
+}
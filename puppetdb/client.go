@@ -2,8 +2,7 @@ package puppetdb
 
 import (
 	"bytes"
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,39 +10,109 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/sebastianrakel/openvoxview/config"
+	"github.com/sebastianrakel/openvoxview/metrics"
 	"github.com/sebastianrakel/openvoxview/model"
 )
 
-type client struct {
+type Client struct {
+	*connection
+	config *config.Config
+
+	cache       *responseCache
+	bypassCache bool
+}
+
+// connection is shared by a client and its copies from WithoutCache
+type connection struct {
+	mu         sync.Mutex
+	httpClient *http.Client
+	watcher    *fsnotify.Watcher
 }
 
 type PdbQuery struct {
 	Query       []any  `json:"query"`
 	SummarizeBy string `json:"summarize_by,omitempty"`
+
+	// paging, see https://github.com/OpenVoxProject/openvoxdb/blob/8.12.1/documentation/api/query/v4/paging.markdown
+	Limit        int          `json:"limit,omitempty"`
+	Offset       int          `json:"offset,omitempty"`
+	OrderBy      []PdbOrderBy `json:"order_by,omitempty"`
+	IncludeTotal bool         `json:"include_total,omitempty"`
+}
+
+type PdbOrderBy struct {
+	Field string `json:"field"`
+	Order string `json:"order,omitempty"`
 }
 
 type PdbBadQueryError error
 
-func NewClient() *client {
-	return &client{}
+// pqlRequest is the payload of a raw PQL query
+type pqlRequest struct {
+	Query string `json:"query"`
 }
 
-func (c *client) call(httpMethod string, endpoint string, payload any, query url.Values, responseData any) (*http.Response, int, error) {
-	cfg, err := config.GetConfig()
-	if err != nil {
-		return nil, http.StatusInternalServerError, err
+// NewClient returns a long-lived PuppetDB client. The underlying http transport is
+// shared between all requests and rebuilt when the configured tls files change.
+func NewClient(config *config.Config) *Client {
+	c := &Client{
+		connection: &connection{},
+		config:     config,
 	}
 
-	uri := fmt.Sprintf("%s/%s", cfg.GetPuppetDbAddress(), endpoint)
-	if query != nil {
-		uri = fmt.Sprintf("%s?%s", uri, query.Encode())
+	if config.Cache.Enabled {
+		c.cache = newResponseCache(config.Cache.MaxEntries)
+	}
+
+	if err := c.watchCertificates(); err != nil {
+		slog.Warn("puppetdb certificate hot reload disabled", "error", err)
 	}
 
+	return c
+}
+
+func (c *Client) call(httpMethod string, endpoint string, payload any, query url.Values, responseData any) (*http.Response, int, error) {
+	return c.callContext(context.Background(), httpMethod, endpoint, payload, query, responseData)
+}
+
+// WithoutCache returns a client that does not use cached responses, the responses it
+// fetches still replace the cached ones
+func (c *Client) WithoutCache() *Client {
+	fresh := *c
+	fresh.bypassCache = true
+	return &fresh
+}
+
+// CacheEntries returns the number of cached responses
+func (c *Client) CacheEntries() int {
+	if c.cache == nil {
+		return 0
+	}
+	return c.cache.len()
+}
+
+func (c *Client) cacheTTL(endpoint string) time.Duration {
+	if c.cache == nil {
+		return 0
+	}
+	return c.config.Cache.TTL[endpoint]
+}
+
+// cacheKeyer is implemented by payloads that have a normalized form for the cache key
+type cacheKeyer interface {
+	cacheKey() string
+}
+
+func (c *Client) callContext(ctx context.Context, httpMethod string, endpoint string, payload any, query url.Values, responseData any) (*http.Response, int, error) {
 	var data []byte
+	var err error
 
 	if payload != nil {
 		data, err = json.Marshal(&payload)
@@ -52,126 +121,312 @@ func (c *client) call(httpMethod string, endpoint string, payload any, query url
 		}
 	}
 
-	slog.Debug("puppet db call", "method", httpMethod, "url", uri)
+	fetch := func(ctx context.Context) (*rawResponse, error) {
+		start := time.Now()
+		response, err := c.do(ctx, httpMethod, endpoint, data, query)
 
-	var tlsConfig *tls.Config
+		statusCode := http.StatusInternalServerError
+		if response != nil {
+			statusCode = response.statusCode
+		}
+		metrics.ObserveUpstream(metrics.UPSTREAM_PUPPETDB, httpMethod, endpointLabel(endpoint), start, statusCode, err)
+
+		return response, err
+	}
+
+	var response *rawResponse
+	cacheName := cacheEndpoint(endpoint)
 
-	if cfg.PuppetDB.TLS {
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: cfg.PuppetDB.TLSIgnore,
+	if ttl := c.cacheTTL(cacheName); ttl > 0 {
+		keyData := string(data)
+		if keyer, isKeyer := payload.(cacheKeyer); isKeyer {
+			keyData = keyer.cacheKey()
 		}
 
-		if cfg.PuppetDB.TLS_CA != "" {
-			caCert, err := os.ReadFile(cfg.PuppetDB.TLS_CA)
-			if err != nil {
-				return nil, 0, err
-			}
-			caCertPool := x509.NewCertPool()
-			caCertPool.AppendCertsFromPEM(caCert)
-			tlsConfig.RootCAs = caCertPool
+		key := fmt.Sprintf("%s\n%s %s?%s\n%s", cacheName, httpMethod, endpoint, query.Encode(), keyData)
+		response, err = c.cache.get(ctx, cacheName, key, ttl, c.bypassCache, fetch)
+	} else {
+		response, err = fetch(ctx)
+	}
+
+	if err != nil {
+		if response == nil {
+			return nil, http.StatusInternalServerError, err
 		}
+		return nil, response.statusCode, err
+	}
 
-		if cfg.PuppetDB.TLS_KEY != "" {
-			cer, err := tls.LoadX509KeyPair(cfg.PuppetDB.TLS_CERT, cfg.PuppetDB.TLS_KEY)
-			if err != nil {
-				return nil, 0, err
-			}
+	httpResp := &http.Response{
+		StatusCode: response.statusCode,
+		Header:     response.header,
+	}
 
-			tlsConfig.Certificates = []tls.Certificate{cer}
+	switch response.statusCode {
+	case http.StatusOK:
+		err = json.Unmarshal(response.body, responseData)
+		if err != nil {
+			return httpResp, response.statusCode, err
 		}
+	case http.StatusBadRequest:
+		return nil, response.statusCode, errors.New(string(response.body))
 	}
+	return httpResp, response.statusCode, nil
+}
 
-	tr := &http.Transport{
-		TLSClientConfig: tlsConfig,
+// endpointLabel strips the names from the endpoint, so the metrics have a bounded
+// number of endpoints
+func endpointLabel(endpoint string) string {
+	if strings.HasPrefix(endpoint, "metrics/v2/read/") {
+		return "metrics/v2/read/:mbean"
 	}
 
-	httpClient := &http.Client{
-		Transport: tr,
+	parts := strings.Split(endpoint, "/")
+	if len(parts) > 4 {
+		return strings.Join(parts[:4], "/") + "/:id"
+	}
+
+	return endpoint
+}
+
+func (c *Client) do(ctx context.Context, httpMethod string, endpoint string, data []byte, query url.Values) (*rawResponse, error) {
+	uri := fmt.Sprintf("%s/%s", c.config.GetPuppetDbAddress(), endpoint)
+	if query != nil {
+		uri = fmt.Sprintf("%s?%s", uri, query.Encode())
 	}
 
-	req, err := http.NewRequest(httpMethod, uri, bytes.NewBuffer(data))
+	slog.Debug("puppet db call", "method", httpMethod, "url", uri)
+
+	httpClient, err := c.getHttpClient()
 	if err != nil {
-		return nil, http.StatusInternalServerError, err
+		return nil, err
+	}
+
+	// the timeout covers reading the body, streamed queries only have the
+	// ResponseHeaderTimeout of the transport
+	if c.config.PuppetDB.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.PuppetDB.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, httpMethod, uri, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return resp, http.StatusInternalServerError, err
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
-	responseRaw, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return resp, resp.StatusCode, err
+	response := &rawResponse{
+		statusCode: resp.StatusCode,
+		header:     resp.Header,
 	}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		err = json.Unmarshal(responseRaw, responseData)
-		if err != nil {
-			return resp, resp.StatusCode, err
-		}
-	case http.StatusBadRequest:
-		return nil, resp.StatusCode, errors.New(string(responseRaw))
-	}
-	return resp, resp.StatusCode, nil
+	response.body, err = io.ReadAll(resp.Body)
+	return response, err
 }
 
-func (c *client) Query(query string) ([]json.RawMessage, int, error) {
-	type PuppetDbQueryRequest struct {
-		Query string `json:"query"`
-	}
-
-	requestBody := PuppetDbQueryRequest{
+// Query executes the PQL query, the request is canceled with the context
+func (c *Client) Query(ctx context.Context, query string) ([]json.RawMessage, int, error) {
+	requestBody := pqlRequest{
 		Query: query,
 	}
 
 	resp := []json.RawMessage{}
 
-	_, code, err := c.call(http.MethodPost, "pdb/query/v4", &requestBody, nil, &resp)
+	_, code, err := c.callContext(ctx, http.MethodPost, "pdb/query/v4", &requestBody, nil, &resp)
 
 	return resp, code, err
 }
 
-func (c *client) GetFacts(query *PdbQuery) ([]model.Fact, error) {
+// QueryStream executes the PQL query and returns the unread response body, so large
+// results can be passed on row by row. The request is canceled with the context.
+func (c *Client) QueryStream(ctx context.Context, query string) (io.ReadCloser, int, error) {
+	start := time.Now()
+	body, statusCode, err := c.queryStream(ctx, query)
+	metrics.ObserveUpstream(metrics.UPSTREAM_PUPPETDB, http.MethodPost, "pdb/query/v4", start, statusCode, err)
+
+	return body, statusCode, err
+}
+
+func (c *Client) queryStream(ctx context.Context, query string) (io.ReadCloser, int, error) {
+	data, err := json.Marshal(pqlRequest{Query: query})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	httpClient, err := c.getHttpClient()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	uri := fmt.Sprintf("%s/pdb/query/v4", c.config.GetPuppetDbAddress())
+	slog.Debug("puppet db stream", "url", uri)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		responseRaw, _ := io.ReadAll(resp.Body)
+		return nil, resp.StatusCode, errors.New(string(responseRaw))
+	}
+
+	return resp.Body, resp.StatusCode, nil
+}
+
+func (c *Client) GetFacts(query *PdbQuery) ([]model.Fact, error) {
 	var resp []model.Fact
 	_, _, err := c.call(http.MethodPost, "pdb/query/v4/facts", query, nil, &resp)
 	return resp, err
 }
 
-func (c *client) GetFactNames() (json.RawMessage, error) {
+// GetInventory returns the matching nodes with their facts and, if the query includes
+// the total, the number of all matching nodes
+func (c *Client) GetInventory(query *PdbQuery) ([]model.Inventory, int, error) {
+	var resp []model.Inventory
+	httpResp, _, err := c.call(http.MethodPost, "pdb/query/v4/inventory", query, nil, &resp)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return resp, recordCount(httpResp, len(resp)), nil
+}
+
+func (c *Client) GetFactNames() (json.RawMessage, error) {
 	resp := json.RawMessage{}
 	_, _, err := c.call(http.MethodGet, "pdb/query/v4/fact-names", nil, nil, &resp)
 	return resp, err
 }
 
-func (c *client) GetEventCounts(query *PdbQuery) ([]model.EventCount, error) {
+func (c *Client) GetEventCounts(query *PdbQuery) ([]model.EventCount, error) {
 	var resp []model.EventCount
 	_, _, err := c.call(http.MethodPost, "pdb/query/v4/event-counts", query, nil, &resp)
 	return resp, err
 }
 
-func (c *client) GetNodes(query *PdbQuery) ([]model.Node, error) {
+func (c *Client) GetNodes(query *PdbQuery) ([]model.Node, error) {
 	var resp []model.Node
 	_, _, err := c.call(http.MethodPost, "pdb/query/v4/nodes", query, nil, &resp)
 	return resp, err
 }
 
-func (c *client) GetMetric(metricName string) (model.Metric, error) {
+// GetNode returns the node or nil if PuppetDB does not know it
+func (c *Client) GetNode(certname string) (*model.Node, error) {
+	var resp model.Node
+	_, statusCode, err := c.call(http.MethodGet, fmt.Sprintf("pdb/query/v4/nodes/%s", url.PathEscape(certname)), nil, nil, &resp)
+
+	return lookupResult(&resp, statusCode, err)
+}
+
+// GetCatalog returns the latest catalog of the node or nil if there is none
+func (c *Client) GetCatalog(certname string) (*model.Catalog, error) {
+	var resp model.Catalog
+	_, statusCode, err := c.call(http.MethodGet, fmt.Sprintf("pdb/query/v4/catalogs/%s", url.PathEscape(certname)), nil, nil, &resp)
+
+	return lookupResult(&resp, statusCode, err)
+}
+
+// lookupResult returns the result of a request for a single object, nil without error if
+// PuppetDB does not know it and an error for every other status
+func lookupResult[T any](resp *T, statusCode int, err error) (*T, error) {
+	switch {
+	case err != nil:
+		return nil, err
+	case statusCode == http.StatusOK:
+		return resp, nil
+	case statusCode == http.StatusNotFound:
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("puppetdb responded with status %d", statusCode)
+}
+
+// GetReports returns the matching reports and, if the query includes the total, the
+// number of all matching reports
+func (c *Client) GetReports(query *PdbQuery) ([]model.Report, int, error) {
+	var resp []model.Report
+	httpResp, _, err := c.call(http.MethodPost, "pdb/query/v4/reports", query, nil, &resp)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return resp, recordCount(httpResp, len(resp)), nil
+}
+
+// GetReport returns the report with the given hash or nil if it does not exist
+func (c *Client) GetReport(hash string) (*model.Report, error) {
+	return c.getReport([]any{"=", "hash", hash})
+}
+
+// GetLatestReport returns the latest report of the node or nil if the node has no report
+func (c *Client) GetLatestReport(certname string) (*model.Report, error) {
+	return c.getReport([]any{
+		"and",
+		[]any{"=", "certname", certname},
+		[]any{"=", "latest_report?", true},
+	})
+}
+
+func (c *Client) getReport(query []any) (*model.Report, error) {
+	reports, _, err := c.GetReports(&PdbQuery{Query: query})
+	if err != nil || len(reports) == 0 {
+		return nil, err
+	}
+
+	return &reports[0], nil
+}
+
+// recordCount returns the total of a paged query (X-Records header), fallback is the
+// number of returned rows
+func recordCount(resp *http.Response, fallback int) int {
+	if resp == nil {
+		return fallback
+	}
+
+	total, err := strconv.Atoi(resp.Header.Get("X-Records"))
+	if err != nil {
+		return fallback
+	}
+
+	return total
+}
+
+// GetMetric reads all attributes of the given mbean (e.g. puppetlabs.puppetdb.mq:name=global.depth)
+func (c *Client) GetMetric(mbean string) (model.Metric, error) {
 	var resp model.Metric
-	_, _, err := c.call(http.MethodGet, fmt.Sprintf("metrics/v2/%s", metricName), nil, nil, &resp)
-	return resp, err
+	_, _, err := c.call(http.MethodGet, fmt.Sprintf("metrics/v2/read/%s", escapeMbean(mbean)), nil, nil, &resp)
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.Status != http.StatusOK {
+		return resp, fmt.Errorf("metric %s: %s", mbean, resp.Error)
+	}
+
+	return resp, nil
 }
 
-func (c *client) GetMetricList() (model.MetricList, error) {
+func (c *Client) GetMetricList() (model.MetricList, error) {
 	var resp model.MetricList
 	_, _, err := c.call(http.MethodGet, "metrics/v2/list", nil, nil, &resp)
 	return resp, err
 }
 
-func (c *client) DeactivateNode(certname string) (*model.CommandResponse, error) {
+func (c *Client) DeactivateNode(certname string) (*model.CommandResponse, error) {
 	payload := model.DeactivateNodePayload{
 		Certname:          certname,
 		ProducerTimestamp: time.Now().UTC(),
@@ -190,9 +445,19 @@ func (c *client) DeactivateNode(certname string) (*model.CommandResponse, error)
 		return nil, err
 	}
 
+	if c.cache != nil {
+		c.cache.invalidate("nodes")
+	}
+
 	if statusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", statusCode)
 	}
 
 	return &resp, nil
 }
+
+// escapeMbean escapes a mbean name for the use in a jolokia url path
+func escapeMbean(mbean string) string {
+	escaped := strings.NewReplacer("!", "!!", "/", "!/", "\"", "!\"").Replace(mbean)
+	return url.PathEscape(escaped)
+}
@@ -0,0 +1,384 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FromAST converts a ["from", entity, ...] query, e.g. decoded from json, so it can be
+// written as PQL with String
+func FromAST(ast []any) (*Query, error) {
+	if len(ast) < 2 || ast[0] != "from" {
+		return nil, fmt.Errorf("invalid ast %s: expected [\"from\", entity, ...]", astString(ast))
+	}
+
+	entity, isString := ast[1].(string)
+	if !isString || !IsEntity(entity) {
+		return nil, fmt.Errorf("invalid ast %s: unknown entity %v", astString(ast), ast[1])
+	}
+
+	query := &Query{Entity: entity}
+
+	for _, element := range ast[2:] {
+		clause, err := astList(element)
+		if err != nil {
+			return nil, err
+		}
+
+		switch clause[0] {
+		case "extract":
+			if err := extractFromAST(query, clause); err != nil {
+				return nil, err
+			}
+		case "order_by":
+			if len(clause) != 2 {
+				return nil, fmt.Errorf("invalid ast %s: expected [\"order_by\", [fields]]", astString(clause))
+			}
+			orders, err := astList(clause[1])
+			if err != nil {
+				return nil, err
+			}
+			for _, order := range orders {
+				orderBy, err := orderByFromAST(order)
+				if err != nil {
+					return nil, err
+				}
+				query.OrderBy = append(query.OrderBy, orderBy)
+			}
+		case "limit", "offset":
+			if len(clause) != 2 {
+				return nil, fmt.Errorf("invalid ast %s: expected [%q, number]", astString(clause), clause[0])
+			}
+			number, err := astInt(clause[1])
+			if err != nil {
+				return nil, err
+			}
+			if clause[0] == "limit" {
+				query.Limit = number
+				query.HasLimit = true
+			} else {
+				query.Offset = number
+			}
+		default:
+			where, err := ExprFromAST(clause)
+			if err != nil {
+				return nil, err
+			}
+			query.Where = where
+		}
+	}
+
+	return query, nil
+}
+
+func extractFromAST(query *Query, clause []any) error {
+	if len(clause) < 2 {
+		return fmt.Errorf("invalid ast %s: expected [\"extract\", [fields], ...]", astString(clause))
+	}
+
+	fields, isList := clause[1].([]any)
+	if !isList {
+		fields = []any{clause[1]}
+	}
+
+	for _, field := range fields {
+		name, err := fieldFromAST(field)
+		if err != nil {
+			return err
+		}
+		query.Fields = append(query.Fields, name)
+	}
+
+	for _, element := range clause[2:] {
+		part, err := astList(element)
+		if err != nil {
+			return err
+		}
+
+		if part[0] == "group_by" {
+			for _, field := range part[1:] {
+				name, isString := field.(string)
+				if !isString {
+					return fmt.Errorf("invalid ast %s: group_by needs field names", astString(part))
+				}
+				query.GroupBy = append(query.GroupBy, name)
+			}
+			continue
+		}
+
+		where, err := ExprFromAST(part)
+		if err != nil {
+			return err
+		}
+		query.Where = where
+	}
+
+	return nil
+}
+
+// fieldFromAST returns a field name or a function like ["function", "count"] as count()
+func fieldFromAST(field any) (string, error) {
+	if name, isString := field.(string); isString {
+		return name, nil
+	}
+
+	function, err := astList(field)
+	if err != nil || function[0] != "function" || len(function) < 2 {
+		return "", fmt.Errorf("invalid ast %s: expected a field or [\"function\", name, ...]", astString(field))
+	}
+
+	args := []string{}
+	for i, arg := range function[2:] {
+		text, isString := arg.(string)
+		if !isString {
+			return "", fmt.Errorf("invalid ast %s: function arguments have to be strings", astString(field))
+		}
+		// only the first argument is a field, e.g. to_string(producer_timestamp, "FMDAY")
+		if i > 0 {
+			text = formatValue(text)
+		}
+		args = append(args, text)
+	}
+
+	return Function(fmt.Sprint(function[1]), args...), nil
+}
+
+func orderByFromAST(order any) (OrderBy, error) {
+	if field, isString := order.(string); isString {
+		return OrderBy{Field: field}, nil
+	}
+
+	pair, err := astList(order)
+	field, isString := "", false
+	if err == nil && len(pair) == 2 {
+		field, isString = pair[0].(string)
+	}
+	if !isString {
+		return OrderBy{}, fmt.Errorf("invalid ast %s: expected field or [field, \"asc\"|\"desc\"]", astString(order))
+	}
+
+	return OrderBy{Field: field, Descending: pair[1] == "desc"}, nil
+}
+
+// ExprFromAST converts a condition like ["and", ["=", "certname", "a"], ...]
+func ExprFromAST(ast []any) (Expr, error) {
+	if len(ast) == 0 {
+		return nil, fmt.Errorf("invalid ast []: expected an operator")
+	}
+
+	operator, isString := ast[0].(string)
+	if !isString {
+		return nil, fmt.Errorf("invalid ast %s: expected an operator", astString(ast))
+	}
+
+	switch operator {
+	case "and", "or":
+		exprs := []Expr{}
+		for _, element := range ast[1:] {
+			expr, err := exprFromAST(element)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, expr)
+		}
+		if len(exprs) == 0 {
+			return nil, fmt.Errorf("invalid ast %s: %s needs at least one condition", astString(ast), operator)
+		}
+		if len(exprs) == 1 {
+			return exprs[0], nil
+		}
+		return &BooleanExpr{Operator: operator, Exprs: exprs}, nil
+	case "not":
+		if len(ast) != 2 {
+			return nil, fmt.Errorf("invalid ast %s: expected [\"not\", condition]", astString(ast))
+		}
+		expr, err := exprFromAST(ast[1])
+		if err != nil {
+			return nil, err
+		}
+		return Not(expr), nil
+	case "=", "~", "<", "<=", ">", ">=":
+		field, err := astField(ast, 3)
+		if err != nil {
+			return nil, err
+		}
+		return &CompareExpr{Operator: operator, Field: field, Value: ast[2]}, nil
+	case "null?":
+		field, err := astField(ast, 3)
+		if err != nil {
+			return nil, err
+		}
+		null, isBool := ast[2].(bool)
+		if !isBool {
+			return nil, fmt.Errorf("invalid ast %s: null? needs true or false", astString(ast))
+		}
+		return &NullExpr{Field: field, Null: null}, nil
+	case "~>":
+		field, err := astField(ast, 3)
+		if err != nil {
+			return nil, err
+		}
+		values, err := astList(ast[2])
+		if err != nil {
+			return nil, err
+		}
+		patterns := []string{}
+		for _, value := range values {
+			pattern, isString := value.(string)
+			if !isString {
+				return nil, fmt.Errorf("invalid ast %s: ~> needs regular expression strings", astString(ast))
+			}
+			patterns = append(patterns, pattern)
+		}
+		return RegexArray(field, patterns...), nil
+	case "in":
+		return inFromAST(ast)
+	case "subquery":
+		if len(ast) < 2 || len(ast) > 3 {
+			return nil, fmt.Errorf("invalid ast %s: expected [\"subquery\", entity, condition]", astString(ast))
+		}
+		entity, isString := ast[1].(string)
+		if !isString || !IsEntity(entity) {
+			return nil, fmt.Errorf("invalid ast %s: unknown entity %v", astString(ast), ast[1])
+		}
+		subquery := &SubqueryExpr{Entity: entity}
+		if len(ast) == 3 {
+			where, err := exprFromAST(ast[2])
+			if err != nil {
+				return nil, err
+			}
+			subquery.Where = where
+		}
+		return subquery, nil
+	}
+
+	return nil, fmt.Errorf("invalid ast %s: unknown operator %q", astString(ast), operator)
+}
+
+func inFromAST(ast []any) (Expr, error) {
+	if len(ast) != 3 {
+		return nil, fmt.Errorf("invalid ast %s: expected [\"in\", field, values]", astString(ast))
+	}
+
+	in := &InExpr{}
+
+	switch fields := ast[1].(type) {
+	case string:
+		in.Fields = []string{fields}
+	case []any:
+		for _, field := range fields {
+			name, isString := field.(string)
+			if !isString {
+				return nil, fmt.Errorf("invalid ast %s: expected field names", astString(ast))
+			}
+			in.Fields = append(in.Fields, name)
+		}
+	default:
+		return nil, fmt.Errorf("invalid ast %s: expected field names", astString(ast))
+	}
+
+	target, err := astList(ast[2])
+	if err != nil {
+		return nil, err
+	}
+
+	switch target[0] {
+	case "array":
+		if len(target) != 2 {
+			return nil, fmt.Errorf("invalid ast %s: expected [\"array\", [values]]", astString(target))
+		}
+		in.Values, err = astList(target[1])
+		if err != nil {
+			return nil, err
+		}
+		return in, nil
+	case "from":
+		in.Query, err = FromAST(target)
+	case "extract":
+		in.Query, err = selectFromAST(target)
+	default:
+		return nil, fmt.Errorf("invalid ast %s: expected an array or a subquery", astString(target))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// selectFromAST converts the older ["extract", [fields], ["select_<entity>", condition]]
+func selectFromAST(ast []any) (*Query, error) {
+	if len(ast) != 3 {
+		return nil, fmt.Errorf("invalid ast %s: expected [\"extract\", [fields], [\"select_<entity>\", ...]]", astString(ast))
+	}
+
+	selection, err := astList(ast[2])
+	if err != nil {
+		return nil, err
+	}
+
+	name, _ := selection[0].(string)
+	entity, isSelect := strings.CutPrefix(name, "select_")
+	if !isSelect || !IsEntity(entity) {
+		return nil, fmt.Errorf("invalid ast %s: expected [\"select_<entity>\", ...]", astString(selection))
+	}
+
+	return FromAST([]any{"from", entity, append([]any{"extract", ast[1]}, selection[1:]...)})
+}
+
+func exprFromAST(element any) (Expr, error) {
+	list, err := astList(element)
+	if err != nil {
+		return nil, err
+	}
+	return ExprFromAST(list)
+}
+
+func astList(element any) ([]any, error) {
+	list, isList := element.([]any)
+	if !isList || len(list) == 0 {
+		return nil, fmt.Errorf("invalid ast %s: expected a non-empty list", astString(element))
+	}
+	return list, nil
+}
+
+func astField(ast []any, length int) (string, error) {
+	if len(ast) != length {
+		return "", fmt.Errorf("invalid ast %s: expected [%q, field, value]", astString(ast), ast[0])
+	}
+
+	field, isString := ast[1].(string)
+	if !isString {
+		return "", fmt.Errorf("invalid ast %s: expected a field name", astString(ast))
+	}
+	return field, nil
+}
+
+func astInt(value any) (int, error) {
+	switch number := value.(type) {
+	case int:
+		return number, nil
+	case int64:
+		return int(number), nil
+	case float64:
+		if number == float64(int(number)) {
+			return int(number), nil
+		}
+	case json.Number:
+		integer, err := number.Int64()
+		if err == nil {
+			return int(integer), nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid ast %s: expected an integer", astString(value))
+}
+
+func astString(ast any) string {
+	data, err := json.Marshal(ast)
+	if err != nil {
+		return fmt.Sprint(ast)
+	}
+	return string(data)
+}
@@ -0,0 +1,322 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expr is a condition of a query, AST returns it in the AST syntax and String as PQL
+type Expr interface {
+	AST() []any
+	String() string
+}
+
+// ToAST returns the AST of the expression or nil if there is no expression, which is
+// what puppetdb.PdbQuery expects for "no filter"
+func ToAST(expr Expr) []any {
+	if expr == nil {
+		return nil
+	}
+	return expr.AST()
+}
+
+// BooleanExpr combines expressions with "and" or "or"
+type BooleanExpr struct {
+	Operator string
+	Exprs    []Expr
+}
+
+type NotExpr struct {
+	Expr Expr
+}
+
+// CompareExpr compares a field with =, ~, <, <=, > or >=
+type CompareExpr struct {
+	Operator string
+	Field    string
+	Value    any
+}
+
+// NullExpr is "field is null" or "field is not null"
+type NullExpr struct {
+	Field string
+	Null  bool
+}
+
+// InExpr matches the fields against a list of values or the result of a subquery
+type InExpr struct {
+	Fields []string
+	Values []any
+	Query  *Query
+}
+
+// RegexArrayExpr matches the elements of a path field against regular expressions
+type RegexArrayExpr struct {
+	Field    string
+	Patterns []string
+}
+
+// SubqueryExpr is an implicit subquery like nodes { facts { name = "os" } }
+type SubqueryExpr struct {
+	Entity string
+	Where  Expr
+}
+
+// And combines the expressions, nil expressions are left out. Without expressions the
+// result is nil, a single expression is returned as it is.
+func And(exprs ...Expr) Expr {
+	return combine("and", exprs)
+}
+
+// Or combines the expressions like And
+func Or(exprs ...Expr) Expr {
+	return combine("or", exprs)
+}
+
+func combine(operator string, exprs []Expr) Expr {
+	combined := []Expr{}
+	for _, expr := range exprs {
+		if expr != nil {
+			combined = append(combined, expr)
+		}
+	}
+
+	switch len(combined) {
+	case 0:
+		return nil
+	case 1:
+		return combined[0]
+	}
+
+	return &BooleanExpr{Operator: operator, Exprs: combined}
+}
+
+func Not(expr Expr) Expr {
+	return &NotExpr{Expr: expr}
+}
+
+func Equal(field string, value any) Expr {
+	return &CompareExpr{Operator: "=", Field: field, Value: value}
+}
+
+func NotEqual(field string, value any) Expr {
+	return Not(Equal(field, value))
+}
+
+func Regex(field string, pattern string) Expr {
+	return &CompareExpr{Operator: "~", Field: field, Value: pattern}
+}
+
+func LessThan(field string, value any) Expr {
+	return &CompareExpr{Operator: "<", Field: field, Value: value}
+}
+
+func LessThanEqual(field string, value any) Expr {
+	return &CompareExpr{Operator: "<=", Field: field, Value: value}
+}
+
+func GreaterThan(field string, value any) Expr {
+	return &CompareExpr{Operator: ">", Field: field, Value: value}
+}
+
+func GreaterThanEqual(field string, value any) Expr {
+	return &CompareExpr{Operator: ">=", Field: field, Value: value}
+}
+
+func IsNull(field string) Expr {
+	return &NullExpr{Field: field, Null: true}
+}
+
+func IsNotNull(field string) Expr {
+	return &NullExpr{Field: field, Null: false}
+}
+
+func In(field string, values ...any) Expr {
+	return &InExpr{Fields: []string{field}, Values: values}
+}
+
+// InQuery matches the field against the extracted field of the query, like
+// certname in facts[certname] { name = "os" }
+func InQuery(field string, query *Query) Expr {
+	return &InExpr{Fields: []string{field}, Query: query}
+}
+
+func RegexArray(field string, patterns ...string) Expr {
+	return &RegexArrayExpr{Field: field, Patterns: patterns}
+}
+
+func Subquery(entity string, where Expr) Expr {
+	return &SubqueryExpr{Entity: entity, Where: where}
+}
+
+func (e *BooleanExpr) AST() []any {
+	ast := []any{e.Operator}
+	for _, expr := range e.Exprs {
+		ast = append(ast, expr.AST())
+	}
+	return ast
+}
+
+func (e *BooleanExpr) String() string {
+	parts := []string{}
+	for _, expr := range e.Exprs {
+		part := expr.String()
+		if boolean, isBoolean := expr.(*BooleanExpr); isBoolean && boolean.Operator != e.Operator {
+			part = "(" + part + ")"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " "+e.Operator+" ")
+}
+
+func (e *NotExpr) AST() []any {
+	return []any{"not", e.Expr.AST()}
+}
+
+func (e *NotExpr) String() string {
+	switch expr := e.Expr.(type) {
+	case *CompareExpr:
+		if expr.Operator == "=" || expr.Operator == "~" {
+			return fmt.Sprintf("%s !%s %s", expr.Field, expr.Operator, formatValue(expr.Value))
+		}
+	case *BooleanExpr:
+		return "!(" + expr.String() + ")"
+	}
+
+	return "!" + e.Expr.String()
+}
+
+func (e *CompareExpr) AST() []any {
+	return []any{e.Operator, e.Field, e.Value}
+}
+
+func (e *CompareExpr) String() string {
+	return fmt.Sprintf("%s %s %s", e.Field, e.Operator, formatValue(e.Value))
+}
+
+func (e *NullExpr) AST() []any {
+	return []any{"null?", e.Field, e.Null}
+}
+
+func (e *NullExpr) String() string {
+	if e.Null {
+		return e.Field + " is null"
+	}
+	return e.Field + " is not null"
+}
+
+func (e *InExpr) AST() []any {
+	var fields any = stringsAST(e.Fields)
+	if len(e.Fields) == 1 {
+		fields = e.Fields[0]
+	}
+
+	if e.Query != nil {
+		return []any{"in", fields, e.Query.AST()}
+	}
+
+	values := e.Values
+	if values == nil {
+		values = []any{}
+	}
+	return []any{"in", fields, []any{"array", values}}
+}
+
+func (e *InExpr) String() string {
+	fields := e.Fields[0]
+	if len(e.Fields) > 1 {
+		fields = "[" + strings.Join(e.Fields, ", ") + "]"
+	}
+
+	if e.Query != nil {
+		return fmt.Sprintf("%s in %s", fields, e.Query.String())
+	}
+
+	return fmt.Sprintf("%s in %s", fields, formatValues(e.Values))
+}
+
+func (e *RegexArrayExpr) AST() []any {
+	return []any{"~>", e.Field, stringsAST(e.Patterns)}
+}
+
+func (e *RegexArrayExpr) String() string {
+	return fmt.Sprintf("%s ~> %s", e.Field, formatValues(stringsAST(e.Patterns)))
+}
+
+func (e *SubqueryExpr) AST() []any {
+	if e.Where == nil {
+		return []any{"subquery", e.Entity}
+	}
+	return []any{"subquery", e.Entity, e.Where.AST()}
+}
+
+func (e *SubqueryExpr) String() string {
+	if e.Where == nil {
+		return e.Entity + " {}"
+	}
+	return fmt.Sprintf("%s { %s }", e.Entity, e.Where.String())
+}
+
+func formatValues(values []any) string {
+	formatted := []string{}
+	for _, value := range values {
+		formatted = append(formatted, formatValue(value))
+	}
+	return "[" + strings.Join(formatted, ", ") + "]"
+}
+
+// formatValue writes a PQL literal, strings are double quoted with " escaped
+func formatValue(value any) string {
+	switch typed := value.(type) {
+	case string:
+		return formatString(typed)
+	case bool:
+		return strconv.FormatBool(typed)
+	case float64:
+		return formatFloat(typed, 64)
+	case float32:
+		return formatFloat(float64(typed), 32)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(typed)
+	case json.Number:
+		return typed.String()
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return formatValue(fmt.Sprint(value))
+	}
+	return string(data)
+}
+
+// formatString double quotes the string for lexString: " and the backslashes that would
+// be read as escape are escaped, other backslashes like in \d are written as they are
+func formatString(value string) string {
+	b := strings.Builder{}
+	b.WriteByte('"')
+
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '"':
+			b.WriteString(`\"`)
+		case c == '\\' && (i+1 == len(value) || value[i+1] == '"' || value[i+1] == '\\'):
+			b.WriteString(`\\`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	b.WriteByte('"')
+	return b.String()
+}
+
+// formatFloat keeps the decimal point of whole numbers, so 1000.0 is parsed as a float again
+func formatFloat(value float64, bitSize int) string {
+	formatted := strconv.FormatFloat(value, 'f', -1, bitSize)
+	if !strings.ContainsAny(formatted, ".NI") {
+		formatted += ".0"
+	}
+	return formatted
+}
@@ -0,0 +1,737 @@
+package query
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SyntaxError is a PQL query that can not be parsed, Line and Column start at 1
+type SyntaxError struct {
+	Offset  int
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+func newSyntaxError(input string, offset int, format string, args ...any) *SyntaxError {
+	before := input[:min(offset, len(input))]
+	line := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(before[strings.LastIndex(before, "\n")+1:]) + 1
+
+	return &SyntaxError{
+		Offset:  offset,
+		Line:    line,
+		Column:  column,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	value  any
+	offset int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenString:
+		return fmt.Sprintf("string %s", formatValue(t.value))
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	if t.kind != kind {
+		return false
+	}
+	if kind == tokenIdent {
+		return strings.EqualFold(t.text, text)
+	}
+	return t.text == text
+}
+
+// symbols are checked in order, so the two character symbols come first
+var symbols = []string{"!=", "!~", "<=", ">=", "~>", "=", "~", "<", ">", "!", "{", "}", "[", "]", "(", ")", ","}
+
+func lex(input string) ([]token, error) {
+	tokens := []token{}
+	offset := 0
+
+	for {
+		for offset < len(input) {
+			r, size := utf8.DecodeRuneInString(input[offset:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			offset += size
+		}
+
+		if offset >= len(input) {
+			return append(tokens, token{kind: tokenEOF, offset: offset}), nil
+		}
+
+		start := offset
+		r, _ := utf8.DecodeRuneInString(input[offset:])
+
+		switch {
+		case r == '"' || r == '\'':
+			value, end, err := lexString(input, offset)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: input[start:end], value: value, offset: start})
+			offset = end
+		case unicode.IsDigit(r) || (r == '-' && offset+1 < len(input) && isDigit(input[offset+1])):
+			value, end, err := lexNumber(input, offset)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: input[start:end], value: value, offset: start})
+			offset = end
+		case isIdentStart(r):
+			end, err := lexIdent(input, offset)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: input[start:end], offset: start})
+			offset = end
+		default:
+			symbol := ""
+			for _, candidate := range symbols {
+				if strings.HasPrefix(input[offset:], candidate) {
+					symbol = candidate
+					break
+				}
+			}
+			if symbol == "" {
+				return nil, newSyntaxError(input, offset, "unexpected character %q", r)
+			}
+			tokens = append(tokens, token{kind: tokenSymbol, text: symbol, offset: start})
+			offset += len(symbol)
+		}
+	}
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func isIdentStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isIdentPart(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '?'
+}
+
+// lexString reads a single or double quoted string. Only an escaped quote or backslash
+// is unescaped, every other backslash is kept as it is (e.g. in regular expressions).
+func lexString(input string, offset int) (string, int, error) {
+	quote := input[offset]
+	b := strings.Builder{}
+
+	for i := offset + 1; i < len(input); i++ {
+		switch c := input[i]; {
+		case c == '\\' && i+1 < len(input):
+			if input[i+1] != quote && input[i+1] != '\\' {
+				b.WriteByte(c)
+			}
+			b.WriteByte(input[i+1])
+			i++
+		case c == quote:
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", 0, newSyntaxError(input, offset, "unterminated string")
+}
+
+func lexNumber(input string, offset int) (any, int, error) {
+	end := offset + 1
+	for end < len(input) && (isDigit(input[end]) || strings.ContainsRune(".eE+-", rune(input[end]))) {
+		if (input[end] == '+' || input[end] == '-') && input[end-1] != 'e' && input[end-1] != 'E' {
+			break
+		}
+		end++
+	}
+
+	text := input[offset:end]
+	if integer, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return integer, end, nil
+	}
+	if float, err := strconv.ParseFloat(text, 64); err == nil {
+		return float, end, nil
+	}
+
+	return nil, 0, newSyntaxError(input, offset, "invalid number %q", text)
+}
+
+// lexIdent reads entities, keywords and fields. Fields can be dotted paths with quoted
+// segments, match() segments and array indexes like facts.disks."sda".size or
+// facts.partitions.match("sd.*").mount or facts.processors.models[0].
+func lexIdent(input string, offset int) (int, error) {
+	end := offset
+
+	for end < len(input) {
+		r, size := utf8.DecodeRuneInString(input[end:])
+
+		switch {
+		case isIdentPart(r):
+			end += size
+		case r == '.' && end+1 < len(input):
+			next := input[end+1]
+			switch {
+			case next == '"' || next == '\'':
+				_, stringEnd, err := lexString(input, end+1)
+				if err != nil {
+					return 0, err
+				}
+				end = stringEnd
+			case strings.HasPrefix(input[end+1:], "match("):
+				argumentOffset := end + 1 + len("match(")
+				if argumentOffset >= len(input) || (input[argumentOffset] != '"' && input[argumentOffset] != '\'') {
+					return 0, newSyntaxError(input, argumentOffset, "match() needs a quoted regular expression")
+				}
+				_, stringEnd, err := lexString(input, argumentOffset)
+				if err != nil {
+					return 0, err
+				}
+				if stringEnd >= len(input) || input[stringEnd] != ')' {
+					return 0, newSyntaxError(input, stringEnd, "expected \")\" after the regular expression of match()")
+				}
+				end = stringEnd + 1
+			default:
+				nextRune, _ := utf8.DecodeRuneInString(input[end+1:])
+				if !isIdentPart(nextRune) {
+					return 0, newSyntaxError(input, end+1, "expected a path segment after \".\"")
+				}
+				end++
+			}
+		case r == '[' && end+1 < len(input) && isDigit(input[end+1]):
+			index := end + 1
+			for index < len(input) && isDigit(input[index]) {
+				index++
+			}
+			if index >= len(input) || input[index] != ']' {
+				return end, nil
+			}
+			end = index + 1
+		default:
+			return end, nil
+		}
+	}
+
+	return end, nil
+}
+
+type parser struct {
+	input  string
+	tokens []token
+	pos    int
+}
+
+// Parse parses and validates a PQL query
+func Parse(pql string) (*Query, error) {
+	tokens, err := lex(pql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{input: pql, tokens: tokens}
+
+	query, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, p.errorAt(next, "unexpected %s after the query", next)
+	}
+
+	return query, nil
+}
+
+// WithLimit adds the limit to a PQL query without one. Unlike setting Query.Limit and
+// writing the query with String, the rest of the query is kept as it is written.
+func WithLimit(pql string, limit int) (string, error) {
+	query, err := Parse(pql)
+	if err != nil {
+		return "", err
+	}
+	if query.Limited() {
+		return "", fmt.Errorf("the query already has a limit")
+	}
+
+	tokens, err := lex(pql)
+	if err != nil {
+		return "", err
+	}
+
+	clause := fmt.Sprintf("limit %d", limit)
+
+	// the last token before the end is the closing brace of the query, if it has braces
+	last := tokens[len(tokens)-2]
+	if !last.is(tokenSymbol, "}") {
+		end := last.offset + len(last.text)
+		return pql[:end] + " { " + clause + " }" + pql[end:], nil
+	}
+
+	// the limit goes before an offset of the query, like in String
+	at := last.offset
+	if len(tokens) >= 4 && tokens[len(tokens)-4].is(tokenIdent, "offset") {
+		at = tokens[len(tokens)-4].offset
+	}
+
+	if r, _ := utf8.DecodeLastRuneInString(pql[:at]); !unicode.IsSpace(r) {
+		clause = " " + clause
+	}
+
+	return pql[:at] + clause + " " + pql[at:], nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(kind tokenKind, text string) bool {
+	if p.peek().is(kind, text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if next := p.next(); !next.is(kind, text) {
+		return p.errorAt(next, "expected %q, got %s", text, next)
+	}
+	return nil
+}
+
+func (p *parser) errorAt(t token, format string, args ...any) error {
+	return newSyntaxError(p.input, t.offset, format, args...)
+}
+
+func (p *parser) parseEntity() (string, error) {
+	t := p.next()
+	if t.kind != tokenIdent {
+		return "", p.errorAt(t, "expected an entity, got %s", t)
+	}
+	if !IsEntity(t.text) {
+		return "", p.errorAt(t, "unknown entity %q, expected one of %s", t.text, strings.Join(Entities, ", "))
+	}
+	return t.text, nil
+}
+
+// parseQuery parses entity[fields] { condition paging }, fields and braces are optional
+func (p *parser) parseQuery() (*Query, error) {
+	entity, err := p.parseEntity()
+	if err != nil {
+		return nil, err
+	}
+
+	query := &Query{Entity: entity}
+
+	if p.accept(tokenSymbol, "[") {
+		query.Fields, err = p.parseProjection()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !p.accept(tokenSymbol, "{") {
+		return query, nil
+	}
+
+	if !p.peek().is(tokenSymbol, "}") && !p.atPagingClause() {
+		query.Where, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.parsePaging(query); err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(tokenSymbol, "}"); err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+func (p *parser) parseProjection() ([]string, error) {
+	fields := []string{}
+
+	for {
+		t := p.next()
+		if t.kind != tokenIdent {
+			return nil, p.errorAt(t, "expected a field or function, got %s", t)
+		}
+
+		field := t.text
+		if p.accept(tokenSymbol, "(") {
+			args := []string{}
+			for !p.accept(tokenSymbol, ")") {
+				if len(args) > 0 {
+					if err := p.expect(tokenSymbol, ","); err != nil {
+						return nil, err
+					}
+				}
+
+				arg := p.next()
+				switch arg.kind {
+				case tokenIdent:
+					args = append(args, arg.text)
+				case tokenString:
+					args = append(args, formatValue(arg.value))
+				default:
+					return nil, p.errorAt(arg, "expected a function argument, got %s", arg)
+				}
+			}
+			field = Function(t.text, args...)
+		}
+
+		fields = append(fields, field)
+
+		if p.accept(tokenSymbol, "]") {
+			return fields, nil
+		}
+		if err := p.expect(tokenSymbol, ","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) atPagingClause() bool {
+	t := p.peek()
+	return t.is(tokenIdent, "group") || t.is(tokenIdent, "order") || t.is(tokenIdent, "limit") || t.is(tokenIdent, "offset")
+}
+
+// parsePaging parses group by, order by, limit and offset, each at most once
+func (p *parser) parsePaging(query *Query) error {
+	seen := map[string]bool{}
+
+	for p.atPagingClause() {
+		t := p.next()
+		clause := strings.ToLower(t.text)
+		if seen[clause] {
+			return p.errorAt(t, "%s is given more than once", clause)
+		}
+		seen[clause] = true
+
+		switch clause {
+		case "group", "order":
+			if err := p.expect(tokenIdent, "by"); err != nil {
+				return err
+			}
+
+			fields, err := p.parseFieldList(clause == "order")
+			if err != nil {
+				return err
+			}
+
+			if clause == "group" {
+				for _, field := range fields {
+					query.GroupBy = append(query.GroupBy, field.Field)
+				}
+			} else {
+				query.OrderBy = fields
+			}
+		case "limit", "offset":
+			number := p.next()
+			value, isInteger := number.value.(int64)
+			if number.kind != tokenNumber || !isInteger || value < 0 {
+				return p.errorAt(number, "%s needs a positive integer, got %s", clause, number)
+			}
+
+			if clause == "limit" {
+				query.Limit = int(value)
+				query.HasLimit = true
+			} else {
+				query.Offset = int(value)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) parseFieldList(withOrder bool) ([]OrderBy, error) {
+	fields := []OrderBy{}
+
+	for {
+		t := p.next()
+		if t.kind != tokenIdent {
+			return nil, p.errorAt(t, "expected a field, got %s", t)
+		}
+
+		field := OrderBy{Field: t.text}
+		if withOrder {
+			if p.accept(tokenIdent, "desc") {
+				field.Descending = true
+			} else {
+				p.accept(tokenIdent, "asc")
+			}
+		}
+		fields = append(fields, field)
+
+		if !p.accept(tokenSymbol, ",") {
+			return fields, nil
+		}
+	}
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	return p.parseBoolean("or", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	return p.parseBoolean("and", p.parseNot)
+}
+
+func (p *parser) parseBoolean(operator string, parseOperand func() (Expr, error)) (Expr, error) {
+	exprs := []Expr{}
+
+	for {
+		expr, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if !p.accept(tokenIdent, operator) {
+			break
+		}
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return &BooleanExpr{Operator: operator, Exprs: exprs}, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if p.accept(tokenSymbol, "!") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return Not(expr), nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.peek()
+
+	switch {
+	case t.is(tokenSymbol, "("):
+		p.next()
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenSymbol, ")"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case t.is(tokenSymbol, "["):
+		p.next()
+		fields, err := p.parseFieldList(false)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenSymbol, "]"); err != nil {
+			return nil, err
+		}
+
+		names := []string{}
+		for _, field := range fields {
+			names = append(names, field.Field)
+		}
+
+		in := p.next()
+		if !in.is(tokenIdent, "in") {
+			return nil, p.errorAt(in, "expected \"in\" after a list of fields, got %s", in)
+		}
+		return p.parseIn(names)
+	case t.kind == tokenIdent:
+		p.next()
+		if p.peek().is(tokenSymbol, "{") {
+			return p.parseSubquery(t)
+		}
+		return p.parseCondition(t.text)
+	}
+
+	return nil, p.errorAt(t, "expected a condition, got %s", t)
+}
+
+// parseSubquery parses an implicit subquery like facts { name = "os" }
+func (p *parser) parseSubquery(entity token) (Expr, error) {
+	if !IsEntity(entity.text) {
+		return nil, p.errorAt(entity, "unknown entity %q, expected one of %s", entity.text, strings.Join(Entities, ", "))
+	}
+
+	p.next()
+	subquery := &SubqueryExpr{Entity: entity.text}
+
+	if !p.accept(tokenSymbol, "}") {
+		where, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		subquery.Where = where
+
+		if err := p.expect(tokenSymbol, "}"); err != nil {
+			return nil, err
+		}
+	}
+
+	return subquery, nil
+}
+
+var compareOperators = []string{"=", "!=", "~", "!~", "<", "<=", ">", ">="}
+
+func (p *parser) parseCondition(field string) (Expr, error) {
+	operator := p.next()
+
+	switch {
+	case operator.kind == tokenSymbol && slices.Contains(compareOperators, operator.text):
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, isString := value.(string); !isString && strings.Contains(operator.text, "~") {
+			return nil, p.errorAt(p.tokens[p.pos-1], "%s needs a regular expression string", operator.text)
+		}
+
+		switch operator.text {
+		case "!=":
+			return NotEqual(field, value), nil
+		case "!~":
+			return Not(&CompareExpr{Operator: "~", Field: field, Value: value}), nil
+		}
+		return &CompareExpr{Operator: operator.text, Field: field, Value: value}, nil
+	case operator.is(tokenSymbol, "~>"):
+		values, err := p.parseArray()
+		if err != nil {
+			return nil, err
+		}
+
+		patterns := []string{}
+		for _, value := range values {
+			pattern, isString := value.(string)
+			if !isString {
+				return nil, p.errorAt(operator, "~> needs a list of regular expression strings")
+			}
+			patterns = append(patterns, pattern)
+		}
+		return RegexArray(field, patterns...), nil
+	case operator.is(tokenIdent, "in"):
+		return p.parseIn([]string{field})
+	case operator.is(tokenIdent, "is"):
+		null := !p.accept(tokenIdent, "not")
+		if t := p.next(); !t.is(tokenIdent, "null") {
+			return nil, p.errorAt(t, "expected \"null\", got %s", t)
+		}
+		return &NullExpr{Field: field, Null: null}, nil
+	}
+
+	return nil, p.errorAt(operator, "expected an operator (=, !=, ~, !~, <, <=, >, >=, ~>, in, is) after %q, got %s", field, operator)
+}
+
+// parseIn parses the list of values or the explicit subquery after "in"
+func (p *parser) parseIn(fields []string) (Expr, error) {
+	if p.peek().is(tokenSymbol, "[") {
+		values, err := p.parseArray()
+		if err != nil {
+			return nil, err
+		}
+		return &InExpr{Fields: fields, Values: values}, nil
+	}
+
+	start := p.peek()
+	subquery, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(subquery.Fields) != len(fields) {
+		return nil, p.errorAt(start, "the subquery has to extract %d field(s) like %s[%s]", len(fields), subquery.Entity, strings.Join(fields, ", "))
+	}
+
+	return &InExpr{Fields: fields, Query: subquery}, nil
+}
+
+func (p *parser) parseArray() ([]any, error) {
+	if err := p.expect(tokenSymbol, "["); err != nil {
+		return nil, err
+	}
+
+	values := []any{}
+	for !p.accept(tokenSymbol, "]") {
+		if len(values) > 0 {
+			if err := p.expect(tokenSymbol, ","); err != nil {
+				return nil, err
+			}
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func (p *parser) parseValue() (any, error) {
+	t := p.next()
+
+	switch {
+	case t.kind == tokenString || t.kind == tokenNumber:
+		return t.value, nil
+	case t.is(tokenIdent, "true"):
+		return true, nil
+	case t.is(tokenIdent, "false"):
+		return false, nil
+	}
+
+	return nil, p.errorAt(t, "expected a value (string, number, true or false), got %s", t)
+}
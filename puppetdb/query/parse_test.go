@@ -0,0 +1,205 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	tests := []struct {
+		pql  string
+		want string
+	}{
+		{`nodes {}`, `nodes {}`},
+		{`nodes`, `nodes {}`},
+		{`nodes[certname] { certname = "web1" }`, `nodes[certname] { certname = "web1" }`},
+		{`nodes[certname, report_timestamp] {}`, `nodes[certname, report_timestamp] {}`},
+		{`nodes { certname != 'web1' }`, `nodes { certname != "web1" }`},
+		{`nodes { certname ~ "^web\d+$" }`, `nodes { certname ~ "^web\d+$" }`},
+		{`nodes { certname !~ "web" }`, `nodes { certname !~ "web" }`},
+		{`nodes { certname = "it\"s" }`, `nodes { certname = "it\"s" }`},
+		{`nodes { certname ~ 'a\"b' }`, `nodes { certname ~ "a\\\"b" }`},
+		{`nodes { certname = 'it\'s "quoted"' }`, `nodes { certname = "it's \"quoted\"" }`},
+		{`nodes { certname = "C:\\" }`, `nodes { certname = "C:\\" }`},
+		{`nodes { certname = "a\\\\b" }`, `nodes { certname = "a\\\b" }`},
+		{`nodes { certname ~ "^web\\\d\.\"" }`, `nodes { certname ~ "^web\\\d\.\"" }`},
+		{`facts { value < 10 and value <= 1.5 or value > -3 and value >= 1e3 }`, `facts { (value < 10 and value <= 1.5) or (value > -3 and value >= 1000.0) }`},
+		{`facts { (name = "a" or name = "b") and value = true }`, ``},
+		{`facts { !(value = false) }`, ``},
+		{`facts { name in ["a", "b", 1] }`, `facts { name in ["a", "b", 1] }`},
+		{`facts { [name, value] in ["a", "b"] }`, ``},
+		{`nodes { certname in facts[certname] { name = "os" } }`, ``},
+		{`nodes { [certname, facts_environment] in factsets[certname, environment] {} }`, ``},
+		{`nodes { facts { name = "os" and value = "linux" } }`, ``},
+		{`nodes { resources {} }`, ``},
+		{`nodes { deactivated is null and expired is not null }`, ``},
+		{`nodes { certname ~> ["web", "db"] }`, ``},
+		{`inventory[certname] { facts.os.release.major = "9" }`, ``},
+		{`inventory[certname] { facts.disks."sda".size > 100 }`, ``},
+		{`inventory[certname] { facts.partitions.match("sd.*").mount = "/" }`, ``},
+		{`inventory[certname] { facts.processors.models[0] ~ "Intel" }`, ``},
+		{`facts[name, count()] { group by name }`, `facts[name, count()] { group by name }`},
+		{`facts[avg(value)] { name = "uptime_seconds" }`, ``},
+		{`reports[certname] { order by receive_time desc, certname limit 10 offset 20 }`, `reports[certname] { order by receive_time desc, certname limit 10 offset 20 }`},
+		{`reports { order by certname asc }`, `reports { order by certname }`},
+		{`nodes { limit 0 }`, `nodes { limit 0 }`},
+		{`nodes { limit 0 offset 0 }`, `nodes { limit 0 }`},
+		{`nodes {
+			certname = "web1"
+			limit 5
+		}`, `nodes { certname = "web1" limit 5 }`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pql, func(t *testing.T) {
+			parsed, err := Parse(test.pql)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			pql := parsed.String()
+			if test.want != "" && pql != test.want {
+				t.Errorf("String() = %s, want %s", pql, test.want)
+			}
+
+			reparsed, err := Parse(pql)
+			if err != nil {
+				t.Fatalf("parse of %s: %v", pql, err)
+			}
+			if !reflect.DeepEqual(reparsed, parsed) {
+				t.Errorf("parsing %s again gives %#v, want %#v", pql, reparsed, parsed)
+			}
+			if again := reparsed.String(); again != pql {
+				t.Errorf("String() after parsing again = %s, want %s", again, pql)
+			}
+
+			fromAST, err := FromAST(parsed.AST())
+			if err != nil {
+				t.Fatalf("FromAST: %v", err)
+			}
+			if !reflect.DeepEqual(fromAST.AST(), parsed.AST()) {
+				t.Errorf("AST round trip = %v, want %v", fromAST.AST(), parsed.AST())
+			}
+		})
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	values := []string{
+		``,
+		`web1`,
+		`it's "quoted"`,
+		`a\"b`,
+		`a\\"b`,
+		`C:\`,
+		`C:\\`,
+		`\\server\share`,
+		`^web\d+\.example\.com$`,
+		`"\'"`,
+	}
+
+	for _, value := range values {
+		t.Run(value, func(t *testing.T) {
+			formatted := formatValue(value)
+
+			lexed, end, err := lexString(formatted, 0)
+			if err != nil {
+				t.Fatalf("lexString(%s): %v", formatted, err)
+			}
+			if lexed != value || end != len(formatted) {
+				t.Errorf("lexString(%s) = %s ending at %d, want %s ending at %d", formatted, lexed, end, value, len(formatted))
+			}
+		})
+	}
+}
+
+func TestWithLimit(t *testing.T) {
+	tests := []struct {
+		pql  string
+		want string
+	}{
+		{`nodes`, `nodes { limit 10 }`},
+		{`nodes[certname]`, `nodes[certname] { limit 10 }`},
+		{`nodes {}`, `nodes { limit 10 }`},
+		{`nodes { certname ~ 'a\"b' }`, `nodes { certname ~ 'a\"b' limit 10 }`},
+		{"nodes {\n  certname = \"web1\"\n}\n", "nodes {\n  certname = \"web1\"\nlimit 10 }\n"},
+		{`reports { order by certname offset 20 }`, `reports { order by certname limit 10 offset 20 }`},
+		{`nodes { certname in reports[certname] { offset 5 } }`, `nodes { certname in reports[certname] { offset 5 } limit 10 }`},
+		{`nodes { value = 1}`, `nodes { value = 1 limit 10 }`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pql, func(t *testing.T) {
+			pql, err := WithLimit(test.pql, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pql != test.want {
+				t.Errorf("WithLimit = %q, want %q", pql, test.want)
+			}
+
+			parsed, err := Parse(pql)
+			if err != nil {
+				t.Fatalf("parse of %s: %v", pql, err)
+			}
+			if parsed.Limit != 10 {
+				t.Errorf("limit of %s = %d, want 10", pql, parsed.Limit)
+			}
+		})
+	}
+
+	for _, pql := range []string{`nodes { limit 5 }`, `nodes { limit 0 }`, `nodes {`} {
+		if _, err := WithLimit(pql, 10); err == nil {
+			t.Errorf("WithLimit(%s) is accepted", pql)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		pql     string
+		line    int
+		column  int
+		message string
+	}{
+		{``, 1, 1, `expected an entity, got end of query`},
+		{`hosts {}`, 1, 1, `unknown entity "hosts", expected one of catalog_input_contents, catalog_inputs, catalogs, edges, environments, event_counts, events, fact_contents, fact_paths, facts, factsets, inventory, nodes, package_inventory, packages, producers, reports, resources`},
+		{`nodes { certname = "web1"`, 1, 26, `expected "}", got end of query`},
+		{`nodes { certname = "web1 }`, 1, 20, `unterminated string`},
+		{`nodes { certname = web1 }`, 1, 20, `expected a value (string, number, true or false), got "web1"`},
+		{`nodes { certname }`, 1, 18, `expected an operator (=, !=, ~, !~, <, <=, >, >=, ~>, in, is) after "certname", got "}"`},
+		{`nodes { certname ~ 1 }`, 1, 20, `~ needs a regular expression string`},
+		{`nodes { certname ~> ["web", 1] }`, 1, 18, `~> needs a list of regular expression strings`},
+		{`nodes { certname is true }`, 1, 21, `expected "null", got "true"`},
+		{`nodes { certname = "web1" } nodes`, 1, 29, `unexpected "nodes" after the query`},
+		{`nodes { certname = "a" # }`, 1, 24, `unexpected character '#'`},
+		{`nodes { limit -1 }`, 1, 15, `limit needs a positive integer, got "-1"`},
+		{`nodes { limit 1.5 }`, 1, 15, `limit needs a positive integer, got "1.5"`},
+		{`nodes { limit 1 limit 2 }`, 1, 17, `limit is given more than once`},
+		{`nodes { order certname }`, 1, 15, `expected "by", got "certname"`},
+		{`nodes[] {}`, 1, 7, `expected a field or function, got "]"`},
+		{`nodes { certname in facts[certname, name] {} }`, 1, 21, `the subquery has to extract 1 field(s) like facts[certname]`},
+		{`nodes { [certname] = "a" }`, 1, 20, `expected "in" after a list of fields, got "="`},
+		{`nodes { hosts { name = "a" } }`, 1, 9, `unknown entity "hosts", expected one of catalog_input_contents, catalog_inputs, catalogs, edges, environments, event_counts, events, fact_contents, fact_paths, facts, factsets, inventory, nodes, package_inventory, packages, producers, reports, resources`},
+		{"nodes {\n  certname = \"a\" and\n  }", 3, 3, `expected a condition, got "}"`},
+		{"nodes {\n\tcertname = \"ä\" or ?\n}", 2, 20, `unexpected character '?'`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pql, func(t *testing.T) {
+			_, err := Parse(test.pql)
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("error = %v, want a syntax error", err)
+			}
+			if syntaxErr.Line != test.line || syntaxErr.Column != test.column {
+				t.Errorf("position = line %d, column %d, want line %d, column %d", syntaxErr.Line, syntaxErr.Column, test.line, test.column)
+			}
+			if syntaxErr.Message != test.message {
+				t.Errorf("message = %s, want %s", syntaxErr.Message, test.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+// Package query builds PuppetDB queries and converts them between PQL and the AST syntax,
+// see https://github.com/OpenVoxProject/openvoxdb/blob/8.12.1/documentation/api/query/v4/ast.markdown
+// and https://github.com/OpenVoxProject/openvoxdb/blob/8.12.1/documentation/api/query/v4/pql.markdown
+package query
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Entities are the entities that can be queried with PQL
+var Entities = []string{
+	"catalog_input_contents",
+	"catalog_inputs",
+	"catalogs",
+	"edges",
+	"environments",
+	"event_counts",
+	"events",
+	"fact_contents",
+	"fact_paths",
+	"facts",
+	"factsets",
+	"inventory",
+	"nodes",
+	"package_inventory",
+	"packages",
+	"producers",
+	"reports",
+	"resources",
+}
+
+func IsEntity(entity string) bool {
+	return slices.Contains(Entities, entity)
+}
+
+// Query is a complete query of an entity like nodes[certname] { certname ~ "web" limit 10 }
+type Query struct {
+	Entity string
+	// Fields are the extracted fields, functions are written like count() or avg(value)
+	Fields  []string
+	Where   Expr
+	GroupBy []string
+	OrderBy []OrderBy
+	Limit   int
+	// HasLimit keeps a limit of 0, a positive Limit is written without it
+	HasLimit bool
+	Offset   int
+}
+
+type OrderBy struct {
+	Field      string
+	Descending bool
+}
+
+// Function returns a function like count() or avg(value) for Query.Fields
+func Function(name string, args ...string) string {
+	return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+}
+
+// Limited is true if the query has a limit, also a limit of 0
+func (q *Query) Limited() bool {
+	return q.HasLimit || q.Limit > 0
+}
+
+// AST returns the query as ["from", entity, ...]
+func (q *Query) AST() []any {
+	ast := []any{"from", q.Entity}
+
+	switch {
+	case len(q.Fields) > 0 || len(q.GroupBy) > 0:
+		extract := []any{"extract", fieldsAST(q.Fields)}
+		if q.Where != nil {
+			extract = append(extract, q.Where.AST())
+		}
+		if len(q.GroupBy) > 0 {
+			extract = append(extract, append([]any{"group_by"}, stringsAST(q.GroupBy)...))
+		}
+		ast = append(ast, extract)
+	case q.Where != nil:
+		ast = append(ast, q.Where.AST())
+	}
+
+	if len(q.OrderBy) > 0 {
+		orderBy := []any{}
+		for _, order := range q.OrderBy {
+			if order.Descending {
+				orderBy = append(orderBy, []any{order.Field, "desc"})
+			} else {
+				orderBy = append(orderBy, order.Field)
+			}
+		}
+		ast = append(ast, []any{"order_by", orderBy})
+	}
+
+	if q.Limited() {
+		ast = append(ast, []any{"limit", q.Limit})
+	}
+
+	if q.Offset > 0 {
+		ast = append(ast, []any{"offset", q.Offset})
+	}
+
+	return ast
+}
+
+// String returns the query as PQL
+func (q *Query) String() string {
+	b := &strings.Builder{}
+	b.WriteString(q.Entity)
+
+	if len(q.Fields) > 0 {
+		b.WriteString("[")
+		b.WriteString(strings.Join(q.Fields, ", "))
+		b.WriteString("]")
+	}
+
+	clauses := []string{}
+	if q.Where != nil {
+		clauses = append(clauses, q.Where.String())
+	}
+
+	if len(q.GroupBy) > 0 {
+		clauses = append(clauses, "group by "+strings.Join(q.GroupBy, ", "))
+	}
+
+	if len(q.OrderBy) > 0 {
+		orders := []string{}
+		for _, order := range q.OrderBy {
+			if order.Descending {
+				orders = append(orders, order.Field+" desc")
+			} else {
+				orders = append(orders, order.Field)
+			}
+		}
+		clauses = append(clauses, "order by "+strings.Join(orders, ", "))
+	}
+
+	if q.Limited() {
+		clauses = append(clauses, fmt.Sprintf("limit %d", q.Limit))
+	}
+
+	if q.Offset > 0 {
+		clauses = append(clauses, fmt.Sprintf("offset %d", q.Offset))
+	}
+
+	if len(clauses) == 0 {
+		b.WriteString(" {}")
+	} else {
+		b.WriteString(" { ")
+		b.WriteString(strings.Join(clauses, " "))
+		b.WriteString(" }")
+	}
+
+	return b.String()
+}
+
+// Entities returns the queried entity and the entities of all subqueries
+func (q *Query) Entities() []string {
+	entities := []string{q.Entity}
+	if q.Where != nil {
+		entities = appendEntities(entities, q.Where)
+	}
+	return entities
+}
+
+func appendEntities(entities []string, expr Expr) []string {
+	switch typed := expr.(type) {
+	case *BooleanExpr:
+		for _, child := range typed.Exprs {
+			entities = appendEntities(entities, child)
+		}
+	case *NotExpr:
+		entities = appendEntities(entities, typed.Expr)
+	case *InExpr:
+		if typed.Query != nil {
+			entities = append(entities, typed.Query.Entities()...)
+		}
+	case *SubqueryExpr:
+		entities = append(entities, typed.Entity)
+		if typed.Where != nil {
+			entities = appendEntities(entities, typed.Where)
+		}
+	}
+	return entities
+}
+
+// fieldsAST converts functions like count() to ["function", "count"]
+func fieldsAST(fields []string) []any {
+	ast := []any{}
+	for _, field := range fields {
+		name, args, isFunction := splitFunction(field)
+		if !isFunction {
+			ast = append(ast, field)
+			continue
+		}
+
+		function := []any{"function", name}
+		for _, arg := range args {
+			function = append(function, arg)
+		}
+		ast = append(ast, function)
+	}
+	return ast
+}
+
+func splitFunction(field string) (string, []string, bool) {
+	open := strings.Index(field, "(")
+	if open <= 0 || !strings.HasSuffix(field, ")") || strings.Contains(field[:open], ".") {
+		return "", nil, false
+	}
+
+	args := []string{}
+	for _, arg := range strings.Split(field[open+1:len(field)-1], ",") {
+		arg = strings.TrimSpace(arg)
+		if arg != "" {
+			args = append(args, strings.Trim(arg, `"'`))
+		}
+	}
+
+	return field[:open], args, true
+}
+
+func stringsAST(values []string) []any {
+	ast := make([]any, len(values))
+	for i, value := range values {
+		ast[i] = value
+	}
+	return ast
+}
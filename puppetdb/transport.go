@@ -0,0 +1,173 @@
+package puppetdb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func (c *Client) getHttpClient() (*http.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.httpClient != nil {
+		return c.httpClient, nil
+	}
+
+	tr, err := c.buildTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	// no client timeout, it would also cut off streamed responses, the timeout of the
+	// regular requests is applied per request in do
+	c.httpClient = &http.Client{
+		Transport: tr,
+	}
+
+	return c.httpClient, nil
+}
+
+func (c *Client) buildTransport() (*http.Transport, error) {
+	var tlsConfig *tls.Config
+
+	cfg := c.config.PuppetDB
+
+	if cfg.TLS {
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: cfg.TLSIgnore,
+		}
+
+		if cfg.TLS_CA != "" {
+			caCert, err := os.ReadFile(cfg.TLS_CA)
+			if err != nil {
+				return nil, err
+			}
+			caCertPool := x509.NewCertPool()
+			caCertPool.AppendCertsFromPEM(caCert)
+			tlsConfig.RootCAs = caCertPool
+		}
+
+		if cfg.TLS_KEY != "" {
+			cer, err := tls.LoadX509KeyPair(cfg.TLS_CERT, cfg.TLS_KEY)
+			if err != nil {
+				return nil, err
+			}
+
+			tlsConfig.Certificates = []tls.Certificate{cer}
+		}
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsConfig
+	tr.MaxIdleConns = cfg.MaxIdleConns
+	tr.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	tr.IdleConnTimeout = cfg.IdleConnTimeout
+	tr.ResponseHeaderTimeout = cfg.Timeout
+
+	return tr, nil
+}
+
+// resetHttpClient drops the cached http client, so the next call builds a new
+// transport with the current tls files
+func (c *Client) resetHttpClient() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.httpClient == nil {
+		return
+	}
+
+	c.httpClient.CloseIdleConnections()
+	c.httpClient = nil
+}
+
+func (c *Client) tlsFiles() []string {
+	cfg := c.config.PuppetDB
+	if !cfg.TLS {
+		return nil
+	}
+
+	files := []string{}
+	for _, file := range []string{cfg.TLS_CA, cfg.TLS_CERT, cfg.TLS_KEY} {
+		if file != "" {
+			files = append(files, filepath.Clean(file))
+		}
+	}
+
+	return files
+}
+
+// watchCertificates watches the directories of the tls files, as certificates are
+// usually replaced by renaming a new file over the old one
+func (c *Client) watchCertificates() error {
+	files := c.tlsFiles()
+	if len(files) == 0 {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := []string{}
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if slices.Contains(dirs, dir) {
+			continue
+		}
+
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+		dirs = append(dirs, dir)
+	}
+
+	c.watcher = watcher
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if !slices.Contains(files, filepath.Clean(event.Name)) {
+					continue
+				}
+
+				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+					slog.Info("puppetdb tls file changed, reloading", "file", event.Name)
+					c.resetHttpClient()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("puppetdb certificate watcher", "error", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops watching the tls files and closes idle connections
+func (c *Client) Close() error {
+	c.resetHttpClient()
+
+	if c.watcher != nil {
+		return c.watcher.Close()
+	}
+
+	return nil
+}
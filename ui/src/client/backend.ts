@@ -6,7 +6,7 @@ import type {
   ApiPredefinedView,
   ApiPredefinedViewResult,
   ApiPuppetQueryPredefined,
-  PuppetQueryHistoryEntry,
+  PuppetQueryHistoryResponse,
   PuppetQueryResult,
 } from 'src/puppet/models';
 import { type ApiPuppetNodeWithEventCount } from 'src/puppet/models/puppet-node';
@@ -21,7 +21,7 @@ class Backend {
     return api.post('/api/v1/pdb/query', payload);
   }
 
-  getQueryHistory(): AxiosPromise<BaseResponse<PuppetQueryHistoryEntry[]>> {
+  getQueryHistory(): AxiosPromise<BaseResponse<PuppetQueryHistoryResponse>> {
     return api.get('/api/v1/pdb/query/history');
   }
 
@@ -52,6 +52,15 @@ class Backend {
     return api.get(`/api/v1/view/node_overview?${queryParams}`)
   }
 
+  getFleetEvents(environment?: string): EventSource {
+    const queryParams = new URLSearchParams();
+    if (environment) {
+      queryParams.append("environment", environment);
+    }
+
+    return new EventSource(`${api.defaults.baseURL ?? ''}/api/v1/events/stream?${queryParams}`, { withCredentials: true })
+  }
+
   getPredefinedViews(): AxiosPromise<BaseResponse<ApiPredefinedView[]>> {
     return api.get('/api/v1/view/predefined')
   }
@@ -12,6 +12,7 @@ export interface ApiMeta {
   UnreportedHours: number;
   StripPathPrefix: string;
   UiDefaultRefreshIntervalInSeconds: number;
+  EventsEnabled: boolean;
 }
 
 export interface ApiVersion {
@@ -0,0 +1,63 @@
+import Backend from 'src/client/backend';
+import { useSettingsStore } from 'stores/settings';
+import { onMounted, onUnmounted, watch } from 'vue';
+
+// events that change the node overview, certificate requests do not
+const nodeEventTypes = ['report', 'status', 'unreported'];
+
+// several nodes often report at once, so the reload waits for them
+const reloadDelayInMs = 2000;
+
+/**
+ * Calls reload when the event stream reports a change of a node in the selected
+ * environment, nothing happens when the events are disabled on the server.
+ */
+export function useNodeEvents(reload: () => void) {
+  const settings = useSettingsStore();
+  let source: EventSource | undefined;
+  let timeout: ReturnType<typeof setTimeout> | undefined;
+  let enabled = false;
+  let mounted = false;
+
+  function scheduleReload() {
+    if (timeout) return;
+    timeout = setTimeout(() => {
+      timeout = undefined;
+      reload();
+    }, reloadDelayInMs);
+  }
+
+  function close() {
+    source?.close();
+    source = undefined;
+    clearTimeout(timeout);
+    timeout = undefined;
+  }
+
+  function open() {
+    close();
+    if (!mounted || !enabled || !settings.environment) return;
+
+    source = Backend.getFleetEvents(settings.hasEnvironment() ? settings.environment : undefined);
+    nodeEventTypes.forEach((type) => {
+      source?.addEventListener(type, scheduleReload);
+    });
+  }
+
+  onMounted(() => {
+    mounted = true;
+    void Backend.getMeta().then((result) => {
+      if (result.status === 200) {
+        enabled = result.data.Data.EventsEnabled;
+        open();
+      }
+    });
+  });
+
+  watch(() => settings.environment, open);
+
+  onUnmounted(() => {
+    mounted = false;
+    close();
+  });
+}
@@ -36,10 +36,17 @@ export interface PuppetQueryResult<T> {
 }
 
 export type PuppetQueryHistoryEntry = {
+  Id: number;
+  Pinned: boolean;
   Query: PuppetQueryRequest;
   Result: PuppetQueryResult<unknown[]>;
 };
 
+export type PuppetQueryHistoryResponse = {
+  Entries: PuppetQueryHistoryEntry[];
+  Total: number;
+};
+
 export interface ApiPuppetQueryPredefined {
   Description: string;
   Query: string;
@@ -54,6 +61,7 @@ export class PuppetQueryPredefined extends autoImplement<ApiPuppetQueryPredefine
 export interface ApiPredefinedViewResult {
   View: ApiPredefinedView;
   Data: unknown[];
+  Total: number;
 }
 
 export class PredefinedViewResult extends autoImplement<ApiPredefinedViewResult>() {
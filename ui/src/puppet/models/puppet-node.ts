@@ -32,6 +32,9 @@ export class PuppetNode extends autoImplement<ApiPuppetNode>() {
 
 export interface ApiPuppetNodeWithEventCount extends PuppetNode {
   events: ApiPuppetEventCount;
+  // computed by openvoxview from report_timestamp and UnreportedHours
+  unreported: boolean;
+  hours_since_report: number | null;
 }
 
 export class PuppetNodeWithEventCount extends autoImplement<ApiPuppetNodeWithEventCount>() {